@@ -0,0 +1,322 @@
+package _storage
+
+import (
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Storage is the backend holding the CAPATH infrastructure files. The paths
+// are the full file paths, including the base path of the CAs.
+type Storage interface {
+	// MakeFolder creates the folder and its missing parents
+	MakeFolder(folderPath string) error
+	// LoadFile returns the file content, or an error matching fs.ErrNotExist
+	LoadFile(filePath string) ([]byte, error)
+	// SaveFile writes the file, never leaving it partially written
+	SaveFile(filePath string, data []byte, perm os.FileMode) error
+	// CopyFile copies the src file to dest
+	CopyFile(src, dest string) error
+	// List returns the names of the folders inside the folder
+	List(folderPath string) ([]string, error)
+	// Exists returns if the file or folder exists
+	Exists(path string) bool
+	// Remove removes the file or the folder with all its content
+	Remove(path string) error
+	// Walk calls walkFn with the path, the size and the modification time of
+	// every file inside the folder, without following symbolic links
+	Walk(folderPath string, walkFn func(path string, size int64, modTime time.Time)) error
+}
+
+// FileSystem is the Storage writing the files to the disk (default)
+type FileSystem struct {
+	DirPermission os.FileMode // Permission of the created folders (default: 0755)
+}
+
+// MakeFolder implements Storage
+func (f FileSystem) MakeFolder(folderPath string) error {
+	perm := f.DirPermission
+	if perm == 0 {
+		perm = 0755
+	}
+
+	return os.MkdirAll(folderPath, perm)
+}
+
+// LoadFile implements Storage
+func (FileSystem) LoadFile(filePath string) ([]byte, error) {
+	return ioutil.ReadFile(filePath)
+}
+
+// SaveFile implements Storage
+func (FileSystem) SaveFile(filePath string, data []byte, perm os.FileMode) error {
+	return writeFileAtomic(filePath, data, perm)
+}
+
+// CopyFile implements Storage
+func (FileSystem) CopyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	inStat, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dest, os.O_RDWR|os.O_CREATE, inStat.Mode())
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	written, err := io.Copy(out, in)
+	if err != nil {
+		return err
+	}
+
+	if written != inStat.Size() {
+		return ErrIncompleteCopy
+	}
+
+	return nil
+}
+
+// List implements Storage
+func (FileSystem) List(folderPath string) ([]string, error) {
+	entries, err := ioutil.ReadDir(folderPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs []string
+	for _, entry := range entries {
+		// follows the symbolic links to folders
+		if info, err := os.Stat(filepath.Join(folderPath, entry.Name())); err == nil && info.IsDir() {
+			dirs = append(dirs, entry.Name())
+		}
+	}
+
+	return dirs, nil
+}
+
+// Exists implements Storage
+func (FileSystem) Exists(path string) bool {
+	_, err := os.Stat(path)
+
+	return !os.IsNotExist(err)
+}
+
+// Remove implements Storage
+func (FileSystem) Remove(path string) error {
+	return os.RemoveAll(path)
+}
+
+// Walk implements Storage. Unreadable files and folders are skipped, and the
+// symbolic links are files of size 0.
+func (FileSystem) Walk(folderPath string, walkFn func(path string, size int64, modTime time.Time)) error {
+	if _, err := os.Stat(folderPath); err != nil {
+		return err
+	}
+
+	return filepath.Walk(folderPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			walkFn(path, info.Size(), info.ModTime())
+		} else if info.Mode()&os.ModeSymlink != 0 {
+			walkFn(path, 0, info.ModTime())
+		}
+		return nil
+	})
+}
+
+type memoryFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+// Memory is a Storage keeping the files in memory, useful for tests. The
+// files are lost when the process exits.
+type Memory struct {
+	mu      sync.RWMutex
+	files   map[string]memoryFile
+	folders map[string]bool
+}
+
+// NewMemory returns an empty Memory storage
+func NewMemory() *Memory {
+	return &Memory{
+		files:   make(map[string]memoryFile),
+		folders: make(map[string]bool),
+	}
+}
+
+func notExist(op, path string) error {
+	return &fs.PathError{Op: op, Path: path, Err: fs.ErrNotExist}
+}
+
+// inside returns if the path is inside the folder
+func inside(path, folderPath string) bool {
+	return strings.HasPrefix(path, folderPath+string(filepath.Separator))
+}
+
+// MakeFolder implements Storage
+func (m *Memory) MakeFolder(folderPath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for path := filepath.Clean(folderPath); ; path = filepath.Dir(path) {
+		m.folders[path] = true
+		if parent := filepath.Dir(path); parent == path {
+			break
+		}
+	}
+
+	return nil
+}
+
+// LoadFile implements Storage
+func (m *Memory) LoadFile(filePath string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	file, ok := m.files[filepath.Clean(filePath)]
+	if !ok {
+		return nil, notExist("open", filePath)
+	}
+
+	return append([]byte(nil), file.data...), nil
+}
+
+// SaveFile implements Storage. The folder must exist, as in the FileSystem.
+func (m *Memory) SaveFile(filePath string, data []byte, perm os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	filePath = filepath.Clean(filePath)
+	if !m.folders[filepath.Dir(filePath)] {
+		return notExist("open", filePath)
+	}
+
+	m.files[filePath] = memoryFile{data: append([]byte(nil), data...), modTime: time.Now()}
+
+	return nil
+}
+
+// CopyFile implements Storage
+func (m *Memory) CopyFile(src, dest string) error {
+	data, err := m.LoadFile(src)
+	if err != nil {
+		return err
+	}
+
+	return m.SaveFile(dest, data, 0644)
+}
+
+// List implements Storage
+func (m *Memory) List(folderPath string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	folderPath = filepath.Clean(folderPath)
+	if !m.folders[folderPath] {
+		return nil, notExist("open", folderPath)
+	}
+
+	var dirs []string
+	for path := range m.folders {
+		if path != folderPath && filepath.Dir(path) == folderPath {
+			dirs = append(dirs, filepath.Base(path))
+		}
+	}
+	sort.Strings(dirs)
+
+	return dirs, nil
+}
+
+// Exists implements Storage
+func (m *Memory) Exists(path string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	path = filepath.Clean(path)
+	_, ok := m.files[path]
+
+	return ok || m.folders[path]
+}
+
+// Remove implements Storage
+func (m *Memory) Remove(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	path = filepath.Clean(path)
+	delete(m.files, path)
+	delete(m.folders, path)
+	for filePath := range m.files {
+		if inside(filePath, path) {
+			delete(m.files, filePath)
+		}
+	}
+	for folderPath := range m.folders {
+		if inside(folderPath, path) {
+			delete(m.folders, folderPath)
+		}
+	}
+
+	return nil
+}
+
+// Walk implements Storage
+func (m *Memory) Walk(folderPath string, walkFn func(path string, size int64, modTime time.Time)) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	folderPath = filepath.Clean(folderPath)
+	if file, ok := m.files[folderPath]; ok {
+		walkFn(folderPath, int64(len(file.data)), file.modTime)
+		return nil
+	}
+	if !m.folders[folderPath] {
+		return notExist("lstat", folderPath)
+	}
+
+	for filePath, file := range m.files {
+		if inside(filePath, folderPath) {
+			walkFn(filePath, int64(len(file.data)), file.modTime)
+		}
+	}
+
+	return nil
+}
+
+// Location is the base path of the CAs and the storage holding their files.
+// The zero Location is the $CAPATH in the file system.
+type Location struct {
+	Path          string       // Base path of the CAs (default: $CAPATH)
+	Storage       Storage      // Storage of the files (default: FileSystem)
+	DirPermission os.FileMode  // Permission of the folders created by the default FileSystem (default: 0755)
+	FileName      FileNameFunc // Names of the files saved and loaded (default: DefaultFileName)
+}
+
+// backend returns the storage of the location, the FileSystem by default
+func (l Location) backend() Storage {
+	if l.Storage != nil {
+		return l.Storage
+	}
+
+	return FileSystem{DirPermission: l.DirPermission}
+}
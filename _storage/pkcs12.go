@@ -0,0 +1,295 @@
+package _storage
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"unicode/utf16"
+)
+
+// pkcs12Iterations is the PKCS#12 key derivation iteration count used to
+// encrypt the private key and compute the MAC
+const pkcs12Iterations = 2048
+
+// ErrPKCS12Password means that the PKCS#12 password has characters that are
+// not supported by the PKCS#12 BMPString encoding
+var ErrPKCS12Password = errors.New("the PKCS#12 password must contain only Basic Multilingual Plane characters")
+
+var (
+	oidDataContentType            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidPBEWithSHAAnd3KeyTripleDES = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidPKCS8ShroudedKeyBag        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertBag                    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidCertTypeX509Certificate    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidFriendlyName               = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyID                 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidSHA1                       = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+)
+
+// pfxPdu is the PKCS#12 PFX (RFC 7292)
+type pfxPdu struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData
+}
+
+// contentInfo is the PKCS#7 ContentInfo (RFC 2315)
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+// macData is the PKCS#12 MacData (RFC 7292)
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int
+}
+
+// digestInfo is the PKCS#7 DigestInfo (RFC 2315)
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+// safeBag is the PKCS#12 SafeBag (RFC 7292)
+type safeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+// pkcs12Attribute is the PKCS#12 bag attribute, a single value SET
+type pkcs12Attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+// certBag is the PKCS#12 CertBag (RFC 7292)
+type certBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+// pbeParams is the PKCS#12 PBE parameters (RFC 7292)
+type pbeParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+// EncodePKCS12 encodes the PKCS#8 private key and the DER certificates, the
+// first one being the private key certificate, as a password protected PKCS#12
+// (.p12/.pfx) file.
+//
+// The private key is encrypted with pbeWithSHAAnd3-KeyTripleDES-CBC and the
+// file integrity is protected with HMAC-SHA1, the algorithms supported by the
+// Windows and Java keystores. The friendly name is set on the private key and
+// its certificate.
+func EncodePKCS12(keyBytes []byte, certificates [][]byte, friendlyName, password string) ([]byte, error) {
+	encodedPassword, err := bmpString(password)
+	if err != nil {
+		return nil, err
+	}
+
+	var attributes []pkcs12Attribute
+	if len(certificates) > 0 {
+		localKeyID := sha1.Sum(certificates[0])
+		attributes, err = bagAttributes(localKeyID[:], friendlyName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var certBags []safeBag
+	for i, certificate := range certificates {
+		bag, err := asn1.Marshal(certBag{ID: oidCertTypeX509Certificate, Data: certificate})
+		if err != nil {
+			return nil, err
+		}
+		certSafeBag := safeBag{ID: oidCertBag, Value: explicitContent(bag)}
+		if i == 0 {
+			certSafeBag.Attributes = attributes
+		}
+		certBags = append(certBags, certSafeBag)
+	}
+
+	shroudedKey, err := encryptPKCS12Key(keyBytes, encodedPassword)
+	if err != nil {
+		return nil, err
+	}
+	keyBags := []safeBag{{ID: oidPKCS8ShroudedKeyBag, Value: explicitContent(shroudedKey), Attributes: attributes}}
+
+	var authenticatedSafe []contentInfo
+	for _, bags := range [][]safeBag{certBags, keyBags} {
+		content, err := dataContentInfo(bags)
+		if err != nil {
+			return nil, err
+		}
+		authenticatedSafe = append(authenticatedSafe, content)
+	}
+	authenticatedSafeBytes, err := asn1.Marshal(authenticatedSafe)
+	if err != nil {
+		return nil, err
+	}
+
+	macSalt := make([]byte, 8)
+	if _, err := rand.Read(macSalt); err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha1.New, pkcs12KDF(encodedPassword, macSalt, 3, pkcs12Iterations, sha1.Size))
+	mac.Write(authenticatedSafeBytes)
+
+	authSafe, err := asn1.Marshal(authenticatedSafeBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(pfxPdu{
+		Version:  3,
+		AuthSafe: contentInfo{ContentType: oidDataContentType, Content: explicitContent(authSafe)},
+		MacData: macData{
+			Mac: digestInfo{
+				Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+				Digest:    mac.Sum(nil),
+			},
+			MacSalt:    macSalt,
+			Iterations: pkcs12Iterations,
+		},
+	})
+}
+
+// dataContentInfo returns the safe bags as a PKCS#7 data ContentInfo
+func dataContentInfo(bags []safeBag) (contentInfo, error) {
+	safeContents, err := asn1.Marshal(bags)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	data, err := asn1.Marshal(safeContents)
+	if err != nil {
+		return contentInfo{}, err
+	}
+
+	return contentInfo{ContentType: oidDataContentType, Content: explicitContent(data)}, nil
+}
+
+// explicitContent returns the DER value as an [0] EXPLICIT tagged value, as
+// encoding/asn1 does not tag a RawValue with FullBytes
+func explicitContent(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+// bagAttributes returns the localKeyId and friendlyName bag attributes
+func bagAttributes(localKeyID []byte, friendlyName string) ([]pkcs12Attribute, error) {
+	keyID, err := asn1.Marshal(localKeyID)
+	if err != nil {
+		return nil, err
+	}
+	attributes := []pkcs12Attribute{{ID: oidLocalKeyID, Value: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: keyID}}}
+
+	if friendlyName != "" {
+		name, err := bmpString(friendlyName)
+		if err != nil {
+			return nil, err
+		}
+		// the BMPString has no NULL terminator
+		nameBytes, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagBMPString, Bytes: name[:len(name)-2]})
+		if err != nil {
+			return nil, err
+		}
+		attributes = append(attributes, pkcs12Attribute{ID: oidFriendlyName, Value: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: nameBytes}})
+	}
+
+	return attributes, nil
+}
+
+// encryptPKCS12Key encrypts the PKCS#8 private key with
+// pbeWithSHAAnd3-KeyTripleDES-CBC returning the EncryptedPrivateKeyInfo
+func encryptPKCS12Key(keyBytes, encodedPassword []byte) ([]byte, error) {
+	salt := make([]byte, 8)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	block, err := des.NewTripleDESCipher(pkcs12KDF(encodedPassword, salt, 1, pkcs12Iterations, 24))
+	if err != nil {
+		return nil, err
+	}
+	iv := pkcs12KDF(encodedPassword, salt, 2, pkcs12Iterations, des.BlockSize)
+
+	// PKCS#7 padding
+	padding := des.BlockSize - len(keyBytes)%des.BlockSize
+	encrypted := append(append([]byte{}, keyBytes...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, encrypted)
+
+	params, err := asn1.Marshal(pbeParams{Salt: salt, Iterations: pkcs12Iterations})
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(encryptedPrivateKeyInfo{
+		EncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidPBEWithSHAAnd3KeyTripleDES, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedData:       encrypted,
+	})
+}
+
+// bmpString returns the password as a NULL terminated BMPString (UTF-16BE)
+func bmpString(s string) ([]byte, error) {
+	encoded := make([]byte, 0, 2*len(s)+2)
+	for _, r := range s {
+		if r > 0xffff || utf16.IsSurrogate(r) {
+			return nil, ErrPKCS12Password
+		}
+		encoded = append(encoded, byte(r>>8), byte(r))
+	}
+
+	return append(encoded, 0, 0), nil
+}
+
+// pkcs12KDF is the PKCS#12 key derivation function with SHA-1 (RFC 7292,
+// appendix B.2), id 1 derives the key, 2 the IV and 3 the MAC key.
+func pkcs12KDF(password, salt []byte, id byte, iterations, size int) []byte {
+	const u, v = sha1.Size, 64
+
+	fill := func(data []byte) []byte {
+		filled := make([]byte, v*((len(data)+v-1)/v))
+		for i := range filled {
+			filled[i] = data[i%len(data)]
+		}
+		return filled
+	}
+
+	D := bytes.Repeat([]byte{id}, v)
+	I := append(fill(salt), fill(password)...)
+
+	var derived []byte
+	for len(derived) < size {
+		hash := sha1.New()
+		hash.Write(D)
+		hash.Write(I)
+		A := hash.Sum(nil)
+		for i := 1; i < iterations; i++ {
+			sum := sha1.Sum(A)
+			A = sum[:]
+		}
+		derived = append(derived, A...)
+
+		// I_j = (I_j + B + 1) mod 2^(v*8), B being A repeated to v bytes
+		B := fill(A[:u])
+		for j := 0; j < len(I); j += v {
+			carry := 1
+			for k := v - 1; k >= 0; k-- {
+				sum := int(I[j+k]) + int(B[k]) + carry
+				I[j+k] = byte(sum)
+				carry = sum >> 8
+			}
+		}
+	}
+
+	return derived[:size]
+}
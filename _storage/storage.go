@@ -1,6 +1,6 @@
 // MIT License
 //
-// Copyright (c) 2020, Kairo de Araujo
+// # Copyright (c) 2020, Kairo de Araujo
 //
 // Permission is hereby granted, free of charge, to any person obtaining a copy
 // of this software and associated documentation files (the "Software"), to deal
@@ -22,102 +22,210 @@
 package _storage
 
 import (
+	"crypto"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/asn1"
 	"encoding/pem"
 	"errors"
-	"io"
+	"io/fs"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 )
 
 // File name constants
 const (
 	PEMFile       = "key.pem"
 	PublicPEMFile = "key.pub"
+	MetadataFile  = "meta.json"
+	IdentityFile  = "identity.json"
+	SerialFile    = "serial"
+	FrozenFile    = "frozen"
+	SerialsFile   = "serials"
 )
 
 var ErrIncompleteCopy = errors.New("file copy was incomplete")
 
-func checkError(err error) error {
+// ErrPathTraversal means that a path, built from a common name such as
+// "../../etc", is outside of the CAs base path.
+var ErrPathTraversal = errors.New("the path is outside of the CA base path")
+
+// within returns if the path is the base path or inside it
+func within(basePath, path string) bool {
+	absBase, err := filepath.Abs(basePath)
+	if err != nil {
+		return false
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+
+	relPath, err := filepath.Rel(absBase, absPath)
+
+	return err == nil && relPath != ".." && !strings.HasPrefix(relPath, ".."+string(filepath.Separator))
+}
+
+// joinIn joins the file path to the base path, failing with ErrPathTraversal
+// if the result is outside of the base path.
+func joinIn(caPath string, filePath ...string) (string, error) {
+	path := filepath.Join(caPath, filepath.Join(filePath...))
+	if !within(caPath, path) {
+		return "", ErrPathTraversal
+	}
+
+	return path, nil
+}
+
+// writeFileAtomic writes the data to a temporary file in the same folder,
+// syncs it to the disk and renames it to fileName, so the file is never
+// partially written (e.g. on power loss).
+func writeFileAtomic(fileName string, data []byte, perm os.FileMode) error {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(fileName), "."+filepath.Base(fileName)+".tmp")
 	if err != nil {
 		return err
 	}
+	tmpName := tmpFile.Name()
 
-	return nil
+	// the temporary file is removed unless renamed
+	defer os.Remove(tmpName)
+
+	if err = tmpFile.Chmod(perm); err == nil {
+		if _, err = tmpFile.Write(data); err == nil {
+			err = tmpFile.Sync()
+		}
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmpName, fileName); err != nil {
+		return err
+	}
+
+	// persist the rename
+	dir, err := os.Open(filepath.Dir(fileName))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
 }
 
-func savePEMKey(fileName string, key *rsa.PrivateKey) {
+// KeyCodecFunc transforms the private key file content (e.g. envelope
+// encryption with a KMS held master key)
+type KeyCodecFunc func(data []byte) ([]byte, error)
 
-	outFile, err := os.Create(fileName)
-	checkError(err)
-	defer outFile.Close()
+// savePrivateKey writes the private key PEM, wrapped by the encoder if set
+func savePrivateKey(s Storage, fileName string, privateKey *pem.Block, encoder KeyCodecFunc) error {
+	data := pem.EncodeToMemory(privateKey)
 
-	err = os.Chmod(fileName, 0600)
-	checkError(err)
+	if encoder != nil {
+		var err error
+		if data, err = encoder(data); err != nil {
+			return err
+		}
+	}
+
+	return s.SaveFile(fileName, data, 0600)
+}
+
+// savePEMKey saves the RSA private key encoded as PKCS#8 (PRIVATE KEY). The
+// keys saved before as PKCS#1 with the same PEM type are still loaded.
+func savePEMKey(s Storage, fileName string, key *rsa.PrivateKey, encoder KeyCodecFunc) error {
+	return savePKCS8PEMKey(s, fileName, key, encoder)
+}
+
+// savePKCS8PEMKey saves the private key encoded as PKCS#8, the format used by
+// algorithms that have no PKCS#1 encoding (e.g. Ed25519).
+func savePKCS8PEMKey(s Storage, fileName string, key crypto.Signer, encoder KeyCodecFunc) error {
+	derBytes, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
 
 	var privateKey = &pem.Block{
 		Type:  "PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(key),
+		Bytes: derBytes,
 	}
 
-	err = pem.Encode(outFile, privateKey)
-	checkError(err)
+	return savePrivateKey(s, fileName, privateKey, encoder)
 }
 
-func savePublicPEMKey(fileName string, pubkey rsa.PublicKey) {
-	asn1Bytes, err := asn1.Marshal(pubkey)
-	checkError(err)
+// saveEncryptedPEMKey saves the private key encoded as PKCS#8 encrypted with
+// the passphrase (ENCRYPTED PRIVATE KEY).
+func saveEncryptedPEMKey(s Storage, fileName string, key crypto.Signer, passphrase []byte, encoder KeyCodecFunc) error {
+	derBytes, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	privateKey, err := EncryptPKCS8(derBytes, passphrase)
+	if err != nil {
+		return err
+	}
+
+	return savePrivateKey(s, fileName, privateKey, encoder)
+}
+
+// savePKIXPublicPEMKey saves the public key encoded as PKIX (SubjectPublicKeyInfo).
+func savePKIXPublicPEMKey(s Storage, fileName string, pubkey crypto.PublicKey) error {
+	derBytes, err := x509.MarshalPKIXPublicKey(pubkey)
+	if err != nil {
+		return err
+	}
 
 	var pemkey = &pem.Block{
 		Type:  "PUBLIC KEY",
-		Bytes: asn1Bytes,
+		Bytes: derBytes,
 	}
 
-	pemfile, err := os.Create(fileName)
-	checkError(err)
-	defer pemfile.Close()
+	return s.SaveFile(fileName, pem.EncodeToMemory(pemkey), 0600)
+}
 
-	err = pem.Encode(pemfile, pemkey)
-	checkError(err)
+func savePublicPEMKey(s Storage, fileName string, pubkey rsa.PublicKey) error {
+	asn1Bytes, err := asn1.Marshal(pubkey)
+	if err != nil {
+		return err
+	}
 
-	err = os.Chmod(fileName, 0600)
-	checkError(err)
+	var pemkey = &pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: asn1Bytes,
+	}
+
+	return s.SaveFile(fileName, pem.EncodeToMemory(pemkey), 0600)
 }
 
-func saveCSR(fileName string, csr []byte) {
+func saveCSR(s Storage, fileName string, csr []byte) error {
 	var pemCSR = &pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}
-	pemfile, err := os.Create(fileName)
-	checkError(err)
-	defer pemfile.Close()
-
-	err = pem.Encode(pemfile, pemCSR)
-	checkError(err)
 
+	return s.SaveFile(fileName, pem.EncodeToMemory(pemCSR), 0644)
 }
 
-func saveCert(fileName string, cert []byte) {
+func saveCert(s Storage, fileName string, cert []byte, chain [][]byte) error {
 	var pemCert = &pem.Block{Type: "CERTIFICATE", Bytes: cert}
-	pemfile, err := os.Create(fileName)
-	checkError(err)
-	defer pemfile.Close()
 
-	err = pem.Encode(pemfile, pemCert)
-	checkError(err)
+	data := pem.EncodeToMemory(pemCert)
+	for _, chainCert := range chain {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: chainCert})...)
+	}
 
+	return s.SaveFile(fileName, data, 0644)
 }
 
-func saveCRL(fileName string, crl []byte) {
+func saveCRL(s Storage, fileName string, crl []byte) error {
 	var pemCRL = &pem.Block{Type: "X509 CRL", Bytes: crl}
-	pemfile, err := os.Create(fileName)
-	checkError(err)
-	defer pemfile.Close()
 
-	err = pem.Encode(pemfile, pemCRL)
-	checkError(err)
+	return s.SaveFile(fileName, pem.EncodeToMemory(pemCRL), 0644)
 }
 
 // File has the content to save a file
@@ -127,26 +235,54 @@ type File struct {
 	FileType       FileType
 	PrivateKeyData *rsa.PrivateKey
 	PublicKeyData  rsa.PublicKey
+	SignerData     crypto.Signer    // Private Key of non RSA algorithms (saved as PKCS#8)
+	PublicData     crypto.PublicKey // Public Key of non RSA algorithms (saved as PKIX)
 	CSRData        []byte
 	CertData       []byte
+	CertChainData  [][]byte // Certificates saved after CertData in the same file
 	CRLData        []byte
+	MetadataData   []byte
+	IdentityData   []byte
+	SerialData     []byte
+	FrozenData     []byte
+	SerialsData    []byte
+	Passphrase     []byte       // Encrypts the private key (PKCS#8 encrypted with AES-256) if set
+	KeyEncoder     KeyCodecFunc // Wraps the private key PEM before it is written (default: nil, the plain PEM)
 	CreationType   CreationType
+	Location       Location // Base path and storage of the CAs (default: $CAPATH in the file system)
 }
 
 // CheckCertExists returns if a certificate exists or not
 func CheckCertExists(f File) bool {
-	caPath, _ := caPathInit()
-	if _, err := os.Stat(filepath.Join(caPath, f.CA, "certs", f.CommonName, f.CommonName+".crt")); os.IsNotExist(err) {
+	caPath, backend, _ := caPathInit(f.Location)
+
+	certPath, err := joinIn(caPath, f.CA, "certs", f.CommonName, FileNameIn(f.Location, FileTypeCertificate, f.CommonName))
+	if err != nil {
 		return false
 	}
 
-	return true
+	return backend.Exists(certPath)
 }
 
 // MakeFolder creates folder inside the CAPATH infrastructure.
 func MakeFolder(folderPath ...string) error {
+	return MakeFolderIn(Location{}, folderPath...)
+}
+
+// MakeFolderIn creates the folder in the storage of the location. The folder
+// path includes the base path (default: $CAPATH), as in MakeFolder, and can
+// not be outside of it (ErrPathTraversal).
+func MakeFolderIn(loc Location, folderPath ...string) error {
+	caPath, backend, err := caPathInit(loc)
+	if err != nil {
+		return err
+	}
 
-	errMakedirAll := os.MkdirAll(filepath.Join(folderPath...), 0755)
+	if !within(caPath, filepath.Join(folderPath...)) {
+		return ErrPathTraversal
+	}
+
+	errMakedirAll := backend.MakeFolder(filepath.Join(folderPath...))
 	if errMakedirAll != nil {
 		return errMakedirAll
 	}
@@ -155,52 +291,108 @@ func MakeFolder(folderPath ...string) error {
 
 }
 
-func caPathInit() (string, error) {
+// ExistsIn returns if the file or folder exists in the location
+func ExistsIn(loc Location, filePath ...string) bool {
+	caPath, backend, err := caPathInit(loc)
+	if err != nil {
+		return false
+	}
+
+	path, err := joinIn(caPath, filePath...)
+	if err != nil {
+		return false
+	}
+
+	return backend.Exists(path)
+}
+
+// RemoveIn removes the file or the folder with all its content from the
+// location
+func RemoveIn(loc Location, filePath ...string) error {
+	caPath, backend, err := caPathInit(loc)
+	if err != nil {
+		return err
+	}
+
+	path, err := joinIn(caPath, filePath...)
+	if err != nil {
+		return err
+	}
+
+	return backend.Remove(path)
+}
+
+// caPathInit returns the base path, creating it if needed. An empty base path
+// is the $CAPATH environment variable, or the current folder if not set.
+// The storage of the location is returned with it.
+func caPathInit(loc Location) (string, Storage, error) {
+	backend := loc.backend()
+	if loc.Path != "" {
+		if err := backend.MakeFolder(loc.Path); err != nil {
+			return "", nil, err
+		}
+
+		return loc.Path, backend, nil
+	}
+
 	CAPATH := os.Getenv("CAPATH")
 
 	if CAPATH == ".//" && os.Getenv("GOCATEST") != "true" {
-		return "", errors.New("not allowed CAPATH=./DoNotUseThisCAPATHTestOnly")
+		return "", nil, errors.New("not allowed CAPATH=./DoNotUseThisCAPATHTestOnly")
 
 	} else if CAPATH == "" {
 		currentPath, err := os.Getwd()
 
 		if err != nil {
-			return "", err
+			return "", nil, err
 		}
 
 		CAPATH = currentPath
 	}
 
-	if _, err := os.Stat(CAPATH); os.IsNotExist(err) {
+	if !backend.Exists(CAPATH) {
 
-		err := MakeFolder(CAPATH)
+		err := backend.MakeFolder(CAPATH)
 		if err != nil {
-			return "", err
+			return "", nil, err
 		}
 
 	}
 
-	return CAPATH, nil
+	return CAPATH, backend, nil
 }
 
 func CAPathIsReady() (string, error) {
 
-	caPath, err := caPathInit()
+	caPath, _, err := caPathInit(Location{})
+
+	return caPath, err
+}
+
+// CAPathIsReadyIn is CAPathIsReady for the location
+func CAPathIsReadyIn(loc Location) (string, error) {
+	caPath, _, err := caPathInit(loc)
 
 	return caPath, err
 }
 
 func CAStorage(commonName string) bool {
-	caPath, err := CAPathIsReady()
+	return CAStorageIn(Location{}, commonName)
+}
+
+// CAStorageIn is CAStorage for the location
+func CAStorageIn(loc Location, commonName string) bool {
+	caPath, backend, err := caPathInit(loc)
 	if err != nil {
 		return false
 	}
 
-	if _, err := os.Stat(filepath.Join(caPath, commonName)); os.IsNotExist(err) {
+	path, err := joinIn(caPath, commonName)
+	if err != nil {
 		return false
 	}
 
-	return true
+	return backend.Exists(path)
 
 }
 
@@ -226,14 +418,74 @@ const (
 	FileTypeCertificate
 	// FileTypeCRL is a Certificate Revoking List file
 	FileTypeCRL
+	// FileTypePublicKey is a Public Key file, saved together with FileTypeKey
+	FileTypePublicKey
+	// FileTypeMetadata is a JSON file with the certificate metadata
+	FileTypeMetadata
+	// FileTypeIdentity is a JSON file with the Identity used to create the CA
+	FileTypeIdentity
+	// FileTypeSerial is the last sequential serial number issued by the CA
+	FileTypeSerial
+	// FileTypeFrozen is the marker of a CA frozen against issuance
+	FileTypeFrozen
+	// FileTypeSerials is the index of the serial numbers issued by the CA
+	FileTypeSerials
 )
 
+// FileNameFunc returns the file name for a FileType owned by the Common Name
+type FileNameFunc func(fileType FileType, commonName string) string
+
+// FileName returns the name of the file saved and loaded in the $CAPATH
+func FileName(fileType FileType, commonName string) string {
+	return FileNameIn(Location{}, fileType, commonName)
+}
+
+// FileNameIn is FileName for the location, named by its FileName (default:
+// DefaultFileName)
+func FileNameIn(loc Location, fileType FileType, commonName string) string {
+	if loc.FileName != nil {
+		return loc.FileName(fileType, commonName)
+	}
+
+	return DefaultFileName(fileType, commonName)
+}
+
+// DefaultFileName returns the default file names: key.pem, key.pub,
+// <common name>.csr, <common name>.crt, <common name>.crl, meta.json,
+// identity.json, serial, frozen and serials
+func DefaultFileName(fileType FileType, commonName string) string {
+	switch fileType {
+	case FileTypeKey:
+		return PEMFile
+	case FileTypePublicKey:
+		return PublicPEMFile
+	case FileTypeCSR:
+		return commonName + ".csr"
+	case FileTypeCertificate:
+		return commonName + ".crt"
+	case FileTypeCRL:
+		return commonName + ".crl"
+	case FileTypeMetadata:
+		return MetadataFile
+	case FileTypeIdentity:
+		return IdentityFile
+	case FileTypeSerial:
+		return SerialFile
+	case FileTypeFrozen:
+		return FrozenFile
+	case FileTypeSerials:
+		return SerialsFile
+	}
+
+	return commonName
+}
+
 // SaveFile saves a File{}
 func SaveFile(f File) error {
 
 	var fileName string
 
-	caDir, err := caPathInit()
+	caDir, backend, err := caPathInit(f.Location)
 	if err != nil {
 		return nil
 
@@ -244,13 +496,17 @@ func SaveFile(f File) error {
 	// Creation type
 	switch f.CreationType {
 	case CreationTypeCA:
-		fileName = filepath.Join(fileName, f.CA, "ca")
+		if fileName, err = joinIn(caDir, f.CA, "ca"); err != nil {
+			return err
+		}
 
 	case CreationTypeCertificate:
-		fileName = filepath.Join(fileName, f.CA, "certs", f.CommonName)
-		if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		if fileName, err = joinIn(caDir, f.CA, "certs", f.CommonName); err != nil {
+			return err
+		}
+		if !backend.Exists(fileName) {
 
-			err := MakeFolder(fileName)
+			err := backend.MakeFolder(fileName)
 			if err != nil {
 				return err
 			}
@@ -260,32 +516,93 @@ func SaveFile(f File) error {
 	// File Type
 	switch f.FileType {
 	case FileTypeKey:
-		savePEMKey(filepath.Join(fileName, PEMFile), f.PrivateKeyData)
-		savePublicPEMKey(filepath.Join(fileName, PublicPEMFile), f.PublicKeyData)
+		keyEncoder := f.KeyEncoder
+		if len(f.Passphrase) > 0 {
+			if f.PrivateKeyData != nil {
+				if err := saveEncryptedPEMKey(backend, filepath.Join(fileName, FileNameIn(f.Location, FileTypeKey, f.CommonName)), f.PrivateKeyData, f.Passphrase, keyEncoder); err != nil {
+					return err
+				}
+				return savePublicPEMKey(backend, filepath.Join(fileName, FileNameIn(f.Location, FileTypePublicKey, f.CommonName)), f.PublicKeyData)
+			}
+			if err := saveEncryptedPEMKey(backend, filepath.Join(fileName, FileNameIn(f.Location, FileTypeKey, f.CommonName)), f.SignerData, f.Passphrase, keyEncoder); err != nil {
+				return err
+			}
+			return savePKIXPublicPEMKey(backend, filepath.Join(fileName, FileNameIn(f.Location, FileTypePublicKey, f.CommonName)), f.PublicData)
+		}
+		if f.PrivateKeyData == nil && f.SignerData != nil {
+			if err := savePKCS8PEMKey(backend, filepath.Join(fileName, FileNameIn(f.Location, FileTypeKey, f.CommonName)), f.SignerData, keyEncoder); err != nil {
+				return err
+			}
+			return savePKIXPublicPEMKey(backend, filepath.Join(fileName, FileNameIn(f.Location, FileTypePublicKey, f.CommonName)), f.PublicData)
+		}
+		if err := savePEMKey(backend, filepath.Join(fileName, FileNameIn(f.Location, FileTypeKey, f.CommonName)), f.PrivateKeyData, keyEncoder); err != nil {
+			return err
+		}
+		return savePublicPEMKey(backend, filepath.Join(fileName, FileNameIn(f.Location, FileTypePublicKey, f.CommonName)), f.PublicKeyData)
 
 	case FileTypeCSR:
-		saveCSR(filepath.Join(fileName, f.CommonName+".csr"), f.CSRData)
+		return saveCSR(backend, filepath.Join(fileName, FileNameIn(f.Location, FileTypeCSR, f.CommonName)), f.CSRData)
 
 	case FileTypeCertificate:
-		saveCert(filepath.Join(fileName, f.CommonName+".crt"), f.CertData)
+		return saveCert(backend, filepath.Join(fileName, FileNameIn(f.Location, FileTypeCertificate, f.CommonName)), f.CertData, f.CertChainData)
 
 	case FileTypeCRL:
-		saveCRL(filepath.Join(fileName, f.CommonName+".crl"), f.CRLData)
+		return saveCRL(backend, filepath.Join(fileName, FileNameIn(f.Location, FileTypeCRL, f.CommonName)), f.CRLData)
+
+	case FileTypeMetadata:
+		return backend.SaveFile(filepath.Join(fileName, FileNameIn(f.Location, FileTypeMetadata, f.CommonName)), f.MetadataData, 0644)
+
+	case FileTypeIdentity:
+		return backend.SaveFile(filepath.Join(fileName, FileNameIn(f.Location, FileTypeIdentity, f.CommonName)), f.IdentityData, 0644)
+
+	case FileTypeSerial:
+		return backend.SaveFile(filepath.Join(fileName, FileNameIn(f.Location, FileTypeSerial, f.CommonName)), f.SerialData, 0644)
+
+	case FileTypeFrozen:
+		return backend.SaveFile(filepath.Join(fileName, FileNameIn(f.Location, FileTypeFrozen, f.CommonName)), f.FrozenData, 0644)
+
+	case FileTypeSerials:
+		return backend.SaveFile(filepath.Join(fileName, FileNameIn(f.Location, FileTypeSerials, f.CommonName)), f.SerialsData, 0644)
 	}
 
 	return nil
 
 }
 
+// LoadKeyFile loads a private key file as LoadFile
+func LoadKeyFile(filePath ...string) ([]byte, error) {
+	return LoadKeyFileIn(Location{}, nil, filePath...)
+}
+
+// LoadKeyFileIn is LoadKeyFile for the location, unwrapped by the decoder if
+// set (the KeyEncoder counterpart of the File)
+func LoadKeyFileIn(loc Location, decoder KeyCodecFunc, filePath ...string) ([]byte, error) {
+	fileData, err := LoadFileIn(loc, filePath...)
+	if err != nil || decoder == nil {
+		return fileData, err
+	}
+
+	return decoder(fileData)
+}
+
 // LoadFile loads a file by file name from $CAPATH
 func LoadFile(filePath ...string) ([]byte, error) {
+	return LoadFileIn(Location{}, filePath...)
+}
+
+// LoadFileIn is LoadFile for the location
+func LoadFileIn(loc Location, filePath ...string) ([]byte, error) {
 	var fileName = filepath.Join(filePath...)
-	caPath, err := CAPathIsReady()
+	caPath, backend, err := caPathInit(loc)
 	if err != nil {
 		return nil, err
 	}
 
-	fileData, err := ioutil.ReadFile(filepath.Join(caPath, fileName))
+	if fileName, err = joinIn(caPath, fileName); err != nil {
+		return nil, err
+	}
+
+	fileData, err := backend.LoadFile(fileName)
 	if err != nil {
 		return []byte{}, err
 	}
@@ -297,63 +614,129 @@ func LoadFile(filePath ...string) ([]byte, error) {
 // CopyFile copies the specified src file to the given destination.
 // Both paths are relative to the $CAPATH hierarchy.
 func CopyFile(src, dest string) error {
-	caPath, err := CAPathIsReady()
+	return CopyFileIn(Location{}, src, dest)
+}
+
+// CopyFileIn is CopyFile for the location
+func CopyFileIn(loc Location, src, dest string) error {
+	caPath, backend, err := caPathInit(loc)
 	if err != nil {
 		return err
 	}
 
-	srcPath := filepath.Join(caPath, src)
-	destPath := filepath.Join(caPath, dest)
-
-	in, err := os.Open(srcPath)
+	srcPath, err := joinIn(caPath, src)
 	if err != nil {
 		return err
 	}
-	defer in.Close()
-
-	inStat, err := in.Stat()
+	destPath, err := joinIn(caPath, dest)
 	if err != nil {
 		return err
 	}
 
-	out, err := os.OpenFile(destPath, os.O_RDWR|os.O_CREATE, inStat.Mode())
+	return backend.CopyFile(srcPath, destPath)
+}
+
+// LatestModTime returns the latest modification time of the files inside a
+// folder in $CAPATH
+func LatestModTime(filePath ...string) (time.Time, error) {
+	return LatestModTimeIn(Location{}, filePath...)
+}
+
+// LatestModTimeIn is LatestModTime for the location
+func LatestModTimeIn(loc Location, filePath ...string) (time.Time, error) {
+	var latest time.Time
+
+	caPath, backend, err := caPathInit(loc)
 	if err != nil {
-		return err
+		return latest, err
 	}
-	defer out.Close()
 
-	written, err := io.Copy(out, in)
+	folderPath, err := joinIn(caPath, filePath...)
 	if err != nil {
-		return err
+		return latest, err
 	}
 
-	if written != inStat.Size() {
-		return ErrIncompleteCopy
+	err = backend.Walk(folderPath, func(path string, size int64, modTime time.Time) {
+		if modTime.After(latest) {
+			latest = modTime
+		}
+	})
+
+	return latest, err
+}
+
+// FolderSize returns the total size in bytes of the files inside a folder in
+// $CAPATH. Unreadable files and folders are skipped.
+func FolderSize(filePath ...string) (int64, error) {
+	return FolderSizeIn(Location{}, filePath...)
+}
+
+// FolderSizeIn is FolderSize for the location
+func FolderSizeIn(loc Location, filePath ...string) (int64, error) {
+	var size int64
+
+	caPath, backend, err := caPathInit(loc)
+	if err != nil {
+		return 0, err
 	}
 
-	return nil
+	folderPath, err := joinIn(caPath, filePath...)
+	if err != nil {
+		return 0, err
+	}
+
+	err = backend.Walk(folderPath, func(path string, fileSize int64, modTime time.Time) {
+		size += fileSize
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+
+	return size, err
 }
 
-func listDirs(paths ...string) []string {
-	var path = filepath.Join(paths...)
-	caPath, err := CAPathIsReady()
+// ListFilesIn returns the paths, relative to the base path of the location,
+// of the files inside a folder. The symbolic links are listed, not followed.
+func ListFilesIn(loc Location, filePath ...string) ([]string, error) {
+	var files []string
+
+	caPath, backend, err := caPathInit(loc)
 	if err != nil {
-		return nil
+		return nil, err
+	}
+
+	folderPath, err := joinIn(caPath, filePath...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = backend.Walk(folderPath, func(path string, size int64, modTime time.Time) {
+		if relPath, err := filepath.Rel(caPath, path); err == nil {
+			files = append(files, relPath)
+		}
+	})
+	if err != nil {
+		return nil, err
 	}
+	sort.Strings(files)
 
-	var dirs []string
+	return files, nil
+}
 
-	files, err := filepath.Glob(filepath.Join(caPath, path, "*"))
+func listDirs(loc Location, paths ...string) []string {
+	var path = filepath.Join(paths...)
+	caPath, backend, err := caPathInit(loc)
 	if err != nil {
 		return nil
 	}
 
-	for _, f := range files {
-		info, _ := os.Stat(f)
-		if info.IsDir() {
-			dirSplited := strings.Split(f, string(os.PathSeparator))
-			dirs = append(dirs, dirSplited[len(dirSplited)-1])
-		}
+	if path, err = joinIn(caPath, path); err != nil {
+		return nil
+	}
+
+	dirs, err := backend.List(path)
+	if err != nil {
+		return nil
 	}
 
 	return dirs
@@ -361,10 +744,20 @@ func listDirs(paths ...string) []string {
 
 // ListCertificates return a list of certificates folders
 func ListCertificates(CACommonName string) []string {
-	return listDirs(CACommonName, "certs")
+	return ListCertificatesIn(Location{}, CACommonName)
+}
+
+// ListCertificatesIn is ListCertificates for the location
+func ListCertificatesIn(loc Location, CACommonName string) []string {
+	return listDirs(loc, CACommonName, "certs")
 }
 
 // ListCAs return a list of certificates folders
 func ListCAs() []string {
-	return listDirs("")
+	return ListCAsIn(Location{})
+}
+
+// ListCAsIn is ListCAs for the location
+func ListCAsIn(loc Location) []string {
+	return listDirs(loc, "")
 }
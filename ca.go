@@ -2,40 +2,118 @@ package goca
 
 import (
 	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
 	"encoding/pem"
 	"errors"
+	"io"
 	"io/fs"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	storage "github.com/kairoaraujo/goca/_storage"
 	"github.com/kairoaraujo/goca/cert"
 	"github.com/kairoaraujo/goca/key"
+	"golang.org/x/crypto/ocsp"
 )
 
-// Const
+// Revocation reason codes (RFC 5280 section 5.3.1)
 const (
-	certExtension string = ".crt"
-	csrExtension  string = ".csr"
-	crlExtension  string = ".crl"
+	RevocationReasonUnspecified          int = 0
+	RevocationReasonKeyCompromise        int = 1
+	RevocationReasonCACompromise         int = 2
+	RevocationReasonAffiliationChanged   int = 3
+	RevocationReasonSuperseded           int = 4
+	RevocationReasonCessationOfOperation int = 5
+	RevocationReasonCertificateHold      int = 6
+	RevocationReasonRemoveFromCRL        int = 8
+	RevocationReasonPrivilegeWithdrawn   int = 9
+	RevocationReasonAACompromise         int = 10
 )
 
+var oidCRLReasonCode = asn1.ObjectIdentifier{2, 5, 29, 21}
+
+// oidCRLNumber is the CRL number extension identifier
+var oidCRLNumber = asn1.ObjectIdentifier{2, 5, 29, 20}
+
+// oidExtKeyUsage is the extended key usage extension identifier
+var oidExtKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37}
+
+// maxOCSPRequestSize is the maximum size of the OCSP requests read by the
+// OCSP handler
+const maxOCSPRequestSize int64 = 10 * 1024
+
+// maxChainLength is the maximum number of CA certificates followed building a
+// certificate chain
+const maxChainLength int = 10
+
+// serialLocks serializes the serial numbers allocation per CA folder, the CA
+// values are copied and can not hold the lock
+var serialLocks sync.Map
+
+// crlLocks serializes the CRL updates per CA folder, as serialLocks
+var crlLocks sync.Map
+
+// crlStateLocks guards the loaded CRL (CAData.CRL, CAData.crl and the CA
+// crlModTime) per CA folder, as GoCRL and GetCRL reload it with
+// WithCRLAutoReload while it is read by concurrent goroutines (e.g. VerifyAll)
+var crlStateLocks sync.Map
+
 // A Identity represents the Certificate Authority Identity Information
 type Identity struct {
-	Organization       string   `json:"organization" example:"Company"`                         // Organization name
-	OrganizationalUnit string   `json:"organization_unit" example:"Security Management"`        // Organizational Unit name
-	Country            string   `json:"country" example:"NL"`                                   // Country (two letters)
-	Locality           string   `json:"locality" example:"Noord-Brabant"`                       // Locality name
-	Province           string   `json:"province" example:"Veldhoven"`                           // Province name
-	EmailAddresses     string   `json:"email" example:"sec@company.com"`                        // Email Address
-	DNSNames           []string `json:"dns_names" example:"ca.example.com,root-ca.example.com"` // DNS Names list
-	Intermediate       bool     `json:"intermediate" example:"false"`                           // Intermendiate Certificate Authority (default is false)
-	KeyBitSize         int      `json:"key_size" example:"2048"`                                // Key Bit Size (defaul: 2048)
-	Valid              int      `json:"valid" example:"365"`                                    // Minimum 1 day, maximum 825 days -- Default: 397
+	Organization        string                  `json:"organization" example:"Company"`                         // Organization name
+	OrganizationalUnit  string                  `json:"organization_unit" example:"Security Management"`        // Organizational Unit name
+	Country             string                  `json:"country" example:"NL"`                                   // Country (two letters)
+	Locality            string                  `json:"locality" example:"Noord-Brabant"`                       // Locality name
+	Province            string                  `json:"province" example:"Veldhoven"`                           // Province name
+	EmailAddresses      string                  `json:"email" example:"sec@company.com"`                        // Email Address
+	SubjectSerialNumber string                  `json:"subject_serial_number" example:"DEVICE-0001"`            // Subject DN serialNumber attribute (not the certificate serial number)
+	EmailPlacement      cert.EmailPlacement     `json:"email_placement" example:"0"`                            // Email Address placement: 0 SAN (default), 1 Subject, 2 both
+	DNSNames            []string                `json:"dns_names" example:"ca.example.com,root-ca.example.com"` // DNS Names list
+	IPAddresses         []net.IP                `json:"ip_addresses" example:"192.0.2.10"`                      // IP Addresses list
+	Intermediate        bool                    `json:"intermediate" example:"false"`                           // Intermendiate Certificate Authority (default is false)
+	KeyBitSize          int                     `json:"key_size" example:"2048"`                                // Key Bit Size (defaul: 2048)
+	KeyAlgorithm        key.KeyAlgorithm        `json:"key_algorithm" example:"RSA"`                            // Key algorithm: RSA (default), Ed25519, ECDSA-P256 or ECDSA-P384
+	Valid               int                     `json:"valid" example:"365"`                                    // Minimum 1 day, maximum 825 days -- Default: 397
+	PolicyOIDs          []asn1.ObjectIdentifier `json:"policy_oids"`                                            // Certificate Policies identifiers (certificatePolicies extension)
+	CPSURIs             []string                `json:"cps_uris" example:"https://pki.example.com/cps"`         // Certification Practice Statement URIs for the policies
+	UPNs                []string                `json:"upns" example:"user@example.com"`                        // User Principal Names (SAN otherName) for Windows smart card logon
+	PermittedDNSDomains []string                `json:"permitted_dns_domains" example:"tenant.example.com"`     // Name Constraints for the CA: permitted DNS domains
+	ExcludedDNSDomains  []string                `json:"excluded_dns_domains" example:"admin.example.com"`       // Name Constraints for the CA: excluded DNS domains
+	OCSPNoCheck         bool                    `json:"ocsp_no_check" example:"false"`                          // Add the OCSP no check extension (delegated OCSP signing certificates)
+	KeepDNSNames        bool                    `json:"keep_dns_names" example:"false"`                         // Keep the DNS Names as requested (default: lowercased and trimmed)
+	ValidityJitter      int                     `json:"validity_jitter" example:"0"`                            // Random ±hours added to the certificate expiration, spreading renewals (default: 0)
+	OCSPServers         []string                `json:"ocsp_servers" example:"http://ocsp.example.com"`         // OCSP responder URLs (Authority Information Access)
+	CAIssuersURLs       []string                `json:"ca_issuers_urls" example:"http://ca.example.com/ca.crt"` // URLs to fetch the issuing CA certificate (Authority Information Access)
+	CRLURLs             []string                `json:"crl_urls" example:"http://crl.example.com/ca.crl"`       // URLs to fetch the CA CRL (CRL Distribution Points)
+	KeyUsage            x509.KeyUsage           `json:"key_usage" example:"1"`                                  // Certificate key usage bits (default: digital signature)
+	ExtKeyUsage         []x509.ExtKeyUsage      `json:"ext_key_usage" example:"2"`                              // Certificate extended key usages (default: client authentication)
+	Issuer              *pkix.Name              `json:"-"`                                                      // Custom Issuer DN, breaks the chain verification if different from the CA subject (legacy migrations only)
+	EmbedChain          bool                    `json:"embed_chain" example:"false"`                            // Store the CA certificate chain after the certificate in the .crt file (offline clients)
+	EmbedRoot           bool                    `json:"embed_root" example:"false"`                             // Include the root CA certificate in the embedded chain
+	MaxPathLen          *int                    `json:"max_path_len" example:"0"`                               // Maximum number of intermediate CAs below the CA (default: unconstrained root, intermediate 0)
 }
 
 // A CAData represents all the Certificate Authority Data as
@@ -49,11 +127,38 @@ type CAData struct {
 	privateKey     rsa.PrivateKey
 	certificate    *x509.Certificate
 	publicKey      rsa.PublicKey
+	signer         crypto.Signer
+	public         crypto.PublicKey
 	csr            *x509.CertificateRequest
 	crl            *pkix.CertificateList
+	identity       Identity
 	IsIntermediate bool
 }
 
+// jsonCAData is the CAData without its methods, marshalled as JSON by the
+// default encoding
+type jsonCAData CAData
+
+// MarshalJSON encodes the CAData as PublicJSON, omitting the CA private key.
+// Use SecretJSON to include it.
+func (c CAData) MarshalJSON() ([]byte, error) {
+	return c.PublicJSON()
+}
+
+// PublicJSON returns the CAData JSON encoded without the CA private key
+func (c CAData) PublicJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		jsonCAData
+		PrivateKey string `json:"private_key,omitempty"`
+	}{jsonCAData: jsonCAData(c)})
+}
+
+// SecretJSON returns the CAData JSON encoded with the CA private key. Keep the
+// result as secret as the private key.
+func (c CAData) SecretJSON() ([]byte, error) {
+	return json.Marshal(jsonCAData(c))
+}
+
 // ErrCAMissingInfo means that all information goca.Information{} is required
 var ErrCAMissingInfo = errors.New("all CA details ('Organization', 'Organizational Unit', 'Country', 'Locality', 'Province') are required")
 
@@ -70,14 +175,220 @@ var ErrCertLoadNotFound = errors.New("the requested Certificate does not exist")
 // ErrCertRevoked means that certificate was not found in $CAPATH to be loaded.
 var ErrCertRevoked = errors.New("the requested Certificate is already revoked")
 
+// ErrCertNotRevoked means that the certificate is not in the CRL.
+var ErrCertNotRevoked = errors.New("the requested Certificate is not revoked")
+
+// ErrInvalidCommonName means that the common name is empty or could escape
+// the $CAPATH, such as "../etc".
+var ErrInvalidCommonName = errors.New("the common name is not valid")
+
+// ErrCertOutsideCAValidity means that the requested certificate validity is
+// not within the CA Certificate validity.
+var ErrCertOutsideCAValidity = errors.New("the certificate validity is outside of the Certificate Authority validity")
+
+// ErrCertInvalid means that the certificate could not be parsed to be verified.
+var ErrCertInvalid = errors.New("the requested Certificate is not valid")
+
+// ErrInvalidRevocationReason means that the revocation reason code is not
+// valid.
+var ErrInvalidRevocationReason = errors.New("the revocation reason code is not valid")
+
+// ErrIssuerNotFound means that none of the CA certificates signed the
+// certificate.
+var ErrIssuerNotFound = errors.New("the certificate issuer was not found")
+
+// ErrNoPrivateKey means that the private key is not available. The errors
+// ErrCertMissingPrivateKey and ErrCAMissingPrivateKey match it with errors.Is.
+var ErrNoPrivateKey = errors.New("the private key is not available")
+
+// missingPrivateKeyError is a specific ErrNoPrivateKey error
+type missingPrivateKeyError struct {
+	message string
+}
+
+func (e *missingPrivateKeyError) Error() string {
+	return e.message
+}
+
+func (e *missingPrivateKeyError) Is(target error) bool {
+	return target == ErrNoPrivateKey
+}
+
+// ErrCertMissingPrivateKey means that the certificate private key is not
+// stored by the CA.
+var ErrCertMissingPrivateKey error = &missingPrivateKeyError{"the requested Certificate private key is not available"}
+
+// ErrManifestSignature means that the status manifest signature is not valid.
+var ErrManifestSignature = errors.New("the status manifest signature is not valid")
+
+// ErrCAMissingPrivateKey means that the CA private key is not available to
+// sign.
+var ErrCAMissingPrivateKey error = &missingPrivateKeyError{"the Certificate Authority private key is not available"}
+
+// ErrCSRMissing means that no CSR (nil) was given to be signed or validated
+var ErrCSRMissing = errors.New("the CSR is missing")
+
+// ErrCSRInvalidSignature means that the CSR signature is not valid
+var ErrCSRInvalidSignature = errors.New("the CSR signature is not valid")
+
+// ErrCSRMissingDNSName means that the CSR does not request a DNS name required
+// by the CSRPolicy
+var ErrCSRMissingDNSName = errors.New("the CSR does not request a required DNS name")
+
+// ErrCSRForbiddenExtKeyUsage means that the CSR requests an extended key usage
+// forbidden by the CSRPolicy
+var ErrCSRForbiddenExtKeyUsage = errors.New("the CSR requests a forbidden extended key usage")
+
+// ErrCSRWeakKey means that the CSR public key is weaker than allowed by the
+// CSRPolicy
+var ErrCSRWeakKey = errors.New("the CSR public key is too weak")
+
+// ErrCANotCRLSigner means that the CA Certificate key usage does not include
+// CRL signing, so the CRL signed by the CA would be rejected by verifiers
+var ErrCANotCRLSigner = errors.New("the Certificate Authority certificate is not allowed to sign CRLs")
+
+// ErrCertPEMInvalid means that the data is not a PEM encoded certificate
+var ErrCertPEMInvalid = errors.New("the data is not a PEM encoded certificate")
+
+// ErrCertPEMMultiple means that the data has more than one certificate when
+// only one is expected
+var ErrCertPEMMultiple = errors.New("the data has more than one certificate")
+
+// ErrSerialNumberInvalid means that the serial number is not positive or
+// longer than 20 octets (RFC 5280).
+var ErrSerialNumberInvalid = errors.New("the serial number must be positive and at most 20 octets")
+
+// ErrSerialNumberExists means that the serial number was already issued by the
+// Certificate Authority.
+var ErrSerialNumberExists = errors.New("the serial number was already issued by the Certificate Authority")
+
+// ErrSerialFileInvalid means that the CA serial file is not a hexadecimal
+// serial number
+var ErrSerialFileInvalid = errors.New("the Certificate Authority serial file is not valid")
+
+// ErrCertExpired means that the certificate is expired or not yet valid.
+var ErrCertExpired = errors.New("the certificate is expired or not yet valid")
+
+// ErrCertUntrusted means that the certificate was not issued by the
+// Certificate Authority or its chain is not trusted.
+var ErrCertUntrusted = errors.New("the certificate was not issued by the Certificate Authority")
+
+// ErrCertNameConstraints means that the certificate names are not permitted
+// by the Name Constraints of the Certificate Authority chain.
+var ErrCertNameConstraints = errors.New("the certificate names are not permitted by the Certificate Authority name constraints")
+
+// ErrCSRNotFound means that the certificate CSR is not stored by the CA.
+var ErrCSRNotFound = errors.New("the requested Certificate CSR is not available")
+
+// ErrCAFrozen means that the CA is frozen and does not issue certificates
+// until it is unfrozen.
+var ErrCAFrozen = errors.New("the Certificate Authority is frozen")
+
+// ErrCAKeyMismatch means that the private key does not match the CA
+// certificate public key.
+var ErrCAKeyMismatch = errors.New("the private key does not match the Certificate Authority certificate")
+
+// ErrCertNotCA means that the certificate is not a CA certificate (basic
+// constraints CA=true).
+var ErrCertNotCA = errors.New("the certificate is not a Certificate Authority certificate")
+
 var ErrParentCommonNameNotSpecified = errors.New("parent common name is empty when creating an intermediate CA certificate")
 
-func (c *CA) create(commonName, parentCommonName string, id Identity) error {
+// listCAs returns the sorted common names of the CAs in the location, the
+// folders with the CA certificate
+func listCAs(loc storage.Location) []string {
+	var commonNames []string
+
+	folders := storage.ListCAsIn(loc)
+	sort.Strings(folders)
+
+	for i, commonName := range folders {
+		if i > 0 && commonName == folders[i-1] {
+			continue
+		}
+		if storage.ExistsIn(loc, commonName, "ca", storage.FileNameIn(loc, storage.FileTypeCertificate, commonName)) {
+			commonNames = append(commonNames, commonName)
+		}
+	}
+
+	return commonNames
+}
+
+// caPath returns the base path of the CA files, the $CAPATH by default.
+func (c *CA) caPath() string {
+	if c.location.Path != "" {
+		return c.location.Path
+	}
+
+	return os.Getenv("CAPATH")
+}
+
+// ErrDomainNotAllowed means that a certificate name is not in the domains
+// allowed for the CA, see WithAllowedDomains.
+var ErrDomainNotAllowed = errors.New("the certificate name is not in the Certificate Authority allowed domains")
+
+// validateCommonName rejects common names that are not safe to be used as a
+// folder name in $CAPATH, such as "..", "../etc" or "/etc".
+func validateCommonName(commonName string) error {
+	if commonName == "" || commonName == "." || commonName == ".." {
+		return ErrInvalidCommonName
+	}
+
+	if filepath.IsAbs(commonName) || strings.ContainsAny(commonName, "/\\\x00") {
+		return ErrInvalidCommonName
+	}
+
+	return nil
+}
+
+// validateDomains rejects the names not in the allowed domains of the CA, if
+// any. A wildcard name is in the domain of its parent name.
+func (c *CA) validateDomains(names []string) error {
+	if len(c.allowedDomains) == 0 {
+		return nil
+	}
+
+	for _, name := range names {
+		name = strings.TrimPrefix(strings.ToLower(strings.TrimSuffix(name, ".")), "*.")
+		allowed := false
+		for _, domain := range c.allowedDomains {
+			domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+			if name == domain || strings.HasSuffix(name, "."+domain) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return ErrDomainNotAllowed
+		}
+	}
+
+	return nil
+}
+
+func (c *CA) create(commonName, parentCommonName string, id Identity) (err error) {
 
 	caData := CAData{}
 
+	if err := validateCommonName(commonName); err != nil {
+		return err
+	}
+
+	if parentCommonName != "" {
+		if err := validateCommonName(parentCommonName); err != nil {
+			return err
+		}
+		if caFrozen(c.location, parentCommonName) {
+			return ErrCAFrozen
+		}
+	}
+
+	if c.serialNumber != nil && (c.serialNumber.Sign() <= 0 || c.serialNumber.BitLen() > 159) {
+		return ErrSerialNumberInvalid
+	}
+
 	// verifies if the CA, based in the 'common name', exists
-	caStorage := storage.CAStorage(commonName)
+	caStorage := storage.CAStorageIn(c.location, commonName)
 	if caStorage {
 		return ErrCAGenerateExists
 	}
@@ -90,45 +401,70 @@ func (c *CA) create(commonName, parentCommonName string, id Identity) error {
 		certBytes       []byte
 		certString      []byte
 		crlString       []byte
-		err             error
 	)
 
 	if id.Organization == "" || id.OrganizationalUnit == "" || id.Country == "" || id.Locality == "" || id.Province == "" {
 		return ErrCAMissingInfo
 	}
 
-	if err := storage.MakeFolder(os.Getenv("CAPATH"), caDir); err != nil {
+	// a failed creation removes the CA folders, so it can be retried
+	defer func() {
+		if err != nil {
+			storage.RemoveIn(c.location, commonName)
+		}
+	}()
+
+	if err := storage.MakeFolderIn(c.location, c.caPath(), caDir); err != nil {
 		return err
 	}
 
-	if err := storage.MakeFolder(os.Getenv("CAPATH"), caCertsDir); err != nil {
+	if err := storage.MakeFolderIn(c.location, c.caPath(), caCertsDir); err != nil {
 		return err
 	}
 
-	caKeys, err := key.CreateKeys(commonName, commonName, storage.CreationTypeCA, id.KeyBitSize)
+	keyOptions := key.KeyOptions{
+		Algorithm:  id.KeyAlgorithm,
+		BitSize:    id.KeyBitSize,
+		Location:   c.location,
+		Passphrase: c.passphrase,
+		KeyEncoder: c.keyEncoder,
+	}
+	caKeys, err := key.CreateKeysWithOptions(commonName, commonName, storage.CreationTypeCA, keyOptions)
 	if err != nil {
 		return err
 	}
 
-	if keyString, err = storage.LoadFile(caDir, "key.pem"); err != nil {
+	if keyString, err = storage.LoadKeyFileIn(c.location, c.keyDecoder, caDir, storage.FileNameIn(c.location, storage.FileTypeKey, commonName)); err != nil {
 		keyString = []byte{}
 	}
 
-	if publicKeyString, err = storage.LoadFile(caCertsDir, "key.pub"); err != nil {
+	if publicKeyString, err = storage.LoadFileIn(c.location, caCertsDir, storage.FileNameIn(c.location, storage.FileTypePublicKey, commonName)); err != nil {
 		publicKeyString = []byte{}
 	}
 
-	privKey := &caKeys.Key
-	pubKey := &caKeys.PublicKey
+	privKey := caKeys.Signer
+	pubKey := caKeys.Public
+
+	caOptions := cert.CAOptions{
+		PermittedDNSDomains: id.PermittedDNSDomains,
+		ExcludedDNSDomains:  id.ExcludedDNSDomains,
+		Location:            c.location,
+		StrictValidity:      c.strictValidity,
+		MaxValid:            c.maxValid,
+		MaxPathLen:          id.MaxPathLen,
+		SerialNumber:        c.serialNumber,
+	}
 
 	caData.privateKey = caKeys.Key
+	caData.signer = caKeys.Signer
 	caData.PrivateKey = string(keyString)
 	caData.publicKey = caKeys.PublicKey
+	caData.public = caKeys.Public
 	caData.PublicKey = string(publicKeyString)
 
 	if !id.Intermediate {
 		caData.IsIntermediate = false
-		certBytes, err = cert.CreateRootCert(
+		certBytes, err = cert.CreateCACertWithOptions(
 			commonName,
 			commonName,
 			id.Country,
@@ -140,7 +476,10 @@ func (c *CA) create(commonName, parentCommonName string, id Identity) error {
 			id.Valid,
 			id.DNSNames,
 			privKey,
+			nil, // parentPrivateKey
+			nil, // parentCertificate
 			pubKey,
+			caOptions,
 			storage.CreationTypeCA,
 		)
 	} else {
@@ -149,15 +488,45 @@ func (c *CA) create(commonName, parentCommonName string, id Identity) error {
 		}
 		var (
 			parentCertificate *x509.Certificate
-			parentPrivateKey  *rsa.PrivateKey
+			parentPrivateKey  crypto.Signer
 		)
 		caData.IsIntermediate = true
-		parentCertificate, parentPrivateKey, err = cert.LoadParentCACertificate(parentCommonName)
+		parentCertificate, parentPrivateKey, err = cert.LoadParentCACertificateWithOptions(parentCommonName, cert.ParentCAOptions{
+			Location:   c.location,
+			Passphrase: c.passphrase,
+			KeyDecoder: c.keyDecoder,
+		})
+		if err != nil {
+			return err
+		}
+
+		// by default, an intermediate CA issues only end-entity certificates
+		if caOptions.MaxPathLen == nil {
+			caOptions.MaxPathLen = new(int)
+		}
+
+		// the serial number is unique among the parent CA issued serials
+		parentCA := &CA{CommonName: parentCommonName, location: c.location}
+		parentCA.Data.certificate = parentCertificate
+		lock, _ := serialLocks.LoadOrStore(filepath.Join(parentCA.caPath(), parentCommonName), &sync.Mutex{})
+		lock.(*sync.Mutex).Lock()
+		defer lock.(*sync.Mutex).Unlock()
+
+		var serials []string
+		serials, err = parentCA.issuedSerials()
 		if err != nil {
-			return nil
+			return err
+		}
+		if caOptions.SerialNumber == nil {
+			caOptions.SerialNumber, err = uniqueSerial(serials)
+			if err != nil {
+				return err
+			}
+		} else if serialIssued(serials, caOptions.SerialNumber) {
+			return ErrSerialNumberExists
 		}
 
-		certBytes, err = cert.CreateCACert(
+		certBytes, err = cert.CreateCACertWithOptions(
 			commonName,
 			commonName,
 			id.Country,
@@ -172,43 +541,219 @@ func (c *CA) create(commonName, parentCommonName string, id Identity) error {
 			parentPrivateKey,
 			parentCertificate,
 			pubKey,
+			caOptions,
 			storage.CreationTypeCA,
 		)
+		if err == nil {
+			err = parentCA.recordSerial(serials, caOptions.SerialNumber)
+		}
 	}
 	if err != nil {
 		return err
 	}
 	certificate, _ := x509.ParseCertificate(certBytes)
 
-	if certString, err = storage.LoadFile(caDir, commonName+certExtension); err != nil {
+	if certString, err = storage.LoadFileIn(c.location, caDir, storage.FileNameIn(c.location, storage.FileTypeCertificate, commonName)); err != nil {
 		certString = []byte{}
 	}
 
 	caData.certificate = certificate
 	caData.Certificate = string(certString)
 
-	crlBytes, err := cert.RevokeCertificate(c.CommonName, []pkix.RevokedCertificate{}, certificate, privKey)
-	if err != nil {
-		crl, err := x509.ParseCRL(crlBytes)
+	// the CA starts with an empty CRL
+	if crlSigner(certificate) {
+		crlBytes, err := cert.RevokeCertificateWithOptions(c.CommonName, []pkix.RevokedCertificate{}, certificate, privKey, cert.CRLOptions{Validity: c.crlValidity, Location: c.location})
 		if err != nil {
-			caData.crl = crl
+			return err
+		}
+		if caData.crl, err = x509.ParseCRL(crlBytes); err != nil {
+			return err
 		}
 	}
 
-	if crlString, err = storage.LoadFile(caDir, commonName+crlExtension); err != nil {
+	if crlString, err = storage.LoadFileIn(c.location, caDir, storage.FileNameIn(c.location, storage.FileTypeCRL, commonName)); err != nil {
 		crlString = []byte{}
 	}
 
-	c.Data.CRL = string(crlString)
+	identityBytes, err := json.Marshal(id)
+	if err != nil {
+		return err
+	}
+	err = storage.SaveFile(storage.File{
+		CA:           commonName,
+		CommonName:   commonName,
+		FileType:     storage.FileTypeIdentity,
+		IdentityData: identityBytes,
+		CreationType: storage.CreationTypeCA,
+		Location:     c.location,
+	})
+	if err != nil {
+		return err
+	}
+	caData.identity = id
+	caData.identity.Issuer = nil
+
+	caData.CRL = string(crlString)
 	c.Data = caData
+	c.certPool.Store((*x509.CertPool)(nil))
 
 	return nil
 }
 
+func (c *CA) importCA(commonName string, certPEM, keyPEM []byte) (err error) {
+
+	if err := validateCommonName(commonName); err != nil {
+		return err
+	}
+
+	if storage.CAStorageIn(c.location, commonName) {
+		return ErrCAGenerateExists
+	}
+
+	imported, err := parseCertificate(certPEM, false)
+	if err != nil {
+		return err
+	}
+	certificate := imported.certificate
+	if !certificate.BasicConstraintsValid || !certificate.IsCA {
+		return ErrCertNotCA
+	}
+
+	signer, err := key.LoadSigner(keyPEM)
+	if err != nil {
+		return err
+	}
+	public, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !public.Equal(certificate.PublicKey) {
+		return ErrCAKeyMismatch
+	}
+
+	// a failed import removes the CA folders, so it can be retried
+	defer func() {
+		if err != nil {
+			storage.RemoveIn(c.location, commonName)
+		}
+	}()
+
+	if err := storage.MakeFolderIn(c.location, c.caPath(), commonName, "ca"); err != nil {
+		return err
+	}
+	if err := storage.MakeFolderIn(c.location, c.caPath(), commonName, "certs"); err != nil {
+		return err
+	}
+
+	keyFile := storage.File{
+		CA:           commonName,
+		CommonName:   commonName,
+		FileType:     storage.FileTypeKey,
+		Passphrase:   c.passphrase,
+		KeyEncoder:   c.keyEncoder,
+		CreationType: storage.CreationTypeCA,
+		Location:     c.location,
+	}
+	if rsaKey, ok := signer.(*rsa.PrivateKey); ok {
+		keyFile.PrivateKeyData = rsaKey
+		keyFile.PublicKeyData = rsaKey.PublicKey
+	} else {
+		keyFile.SignerData = signer
+		keyFile.PublicData = signer.Public()
+	}
+	if err := storage.SaveFile(keyFile); err != nil {
+		return err
+	}
+
+	err = storage.SaveFile(storage.File{
+		CA:           commonName,
+		CommonName:   commonName,
+		FileType:     storage.FileTypeCertificate,
+		CertData:     certificate.Raw,
+		CreationType: storage.CreationTypeCA,
+		Location:     c.location,
+	})
+	if err != nil {
+		return err
+	}
+
+	// the subject details are the identity of the issued certificates
+	subject := certificate.Subject
+	identityBytes, err := json.Marshal(Identity{
+		Organization:       firstOrEmpty(subject.Organization),
+		OrganizationalUnit: firstOrEmpty(subject.OrganizationalUnit),
+		Country:            firstOrEmpty(subject.Country),
+		Locality:           firstOrEmpty(subject.Locality),
+		Province:           firstOrEmpty(subject.Province),
+		Intermediate:       !bytes.Equal(certificate.RawSubject, certificate.RawIssuer),
+	})
+	if err != nil {
+		return err
+	}
+	err = storage.SaveFile(storage.File{
+		CA:           commonName,
+		CommonName:   commonName,
+		FileType:     storage.FileTypeIdentity,
+		IdentityData: identityBytes,
+		CreationType: storage.CreationTypeCA,
+		Location:     c.location,
+	})
+	if err != nil {
+		return err
+	}
+
+	// the CA starts with an empty CRL, if the certificate is allowed to sign
+	// CRLs
+	if crlSigner(certificate) {
+		_, err = cert.RevokeCertificateWithOptions(commonName, []pkix.RevokedCertificate{}, certificate, signer, cert.CRLOptions{Validity: c.crlValidity, Location: c.location})
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.loadCA(commonName)
+}
+
+// deletePaths returns the files removed by delete, relative to the CA path
+func (c *CA) deletePaths() ([]string, error) {
+	if err := validateCommonName(c.CommonName); err != nil {
+		return nil, err
+	}
+
+	if !storage.CAStorageIn(c.location, c.CommonName) {
+		return nil, ErrCALoadNotFound
+	}
+
+	return storage.ListFilesIn(c.location, c.CommonName)
+}
+
+func (c *CA) size() (size int64, certificates int, err error) {
+	if !storage.CAStorageIn(c.location, c.CommonName) {
+		return 0, 0, ErrCALoadNotFound
+	}
+
+	size, err = storage.FolderSizeIn(c.location, c.CommonName)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return size, len(storage.ListCertificatesIn(c.location, c.CommonName)), nil
+}
+
+func (c *CA) delete() error {
+	if _, err := c.deletePaths(); err != nil {
+		return err
+	}
+
+	// the symbolic links are removed, not followed
+	return storage.RemoveIn(c.location, c.CommonName)
+}
+
 func (c *CA) loadCA(commonName string) error {
 
 	caData := CAData{}
 
+	if err := validateCommonName(commonName); err != nil {
+		return err
+	}
+
 	var (
 		caDir           string = filepath.Join(commonName, "ca")
 		keyString       []byte
@@ -220,34 +765,40 @@ func (c *CA) loadCA(commonName string) error {
 	)
 
 	// verifies if the CA, based in the 'common name', exists
-	caStorage := storage.CAStorage(commonName)
+	caStorage := storage.CAStorageIn(c.location, commonName)
 	if !caStorage {
 		return ErrCALoadNotFound
 	}
 
-	if keyString, loadErr = storage.LoadFile(caDir, "key.pem"); loadErr == nil {
-		privateKey, err := key.LoadPrivateKey(keyString)
+	if keyString, loadErr = storage.LoadKeyFileIn(c.location, c.keyDecoder, caDir, storage.FileNameIn(c.location, storage.FileTypeKey, commonName)); loadErr == nil {
+		privateKey, err := key.LoadSignerWithPassphrase(keyString, c.passphrase)
 		if err != nil {
 			return err
 		}
 		caData.PrivateKey = string(keyString)
-		caData.privateKey = *privateKey
+		caData.signer = privateKey
+		if rsaPrivateKey, ok := privateKey.(*rsa.PrivateKey); ok {
+			caData.privateKey = *rsaPrivateKey
+		}
 	} else {
 		return loadErr
 	}
 
-	if publicKeyString, loadErr = storage.LoadFile(caDir, "key.pub"); loadErr == nil {
-		publicKey, err := key.LoadPublicKey(publicKeyString)
+	if publicKeyString, loadErr = storage.LoadFileIn(c.location, caDir, storage.FileNameIn(c.location, storage.FileTypePublicKey, commonName)); loadErr == nil {
+		publicKey, err := key.LoadPublic(publicKeyString)
 		if err != nil {
 			return err
 		}
 		caData.PublicKey = string(publicKeyString)
-		caData.publicKey = *publicKey
+		caData.public = publicKey
+		if rsaPublicKey, ok := publicKey.(*rsa.PublicKey); ok {
+			caData.publicKey = *rsaPublicKey
+		}
 	} else {
 		return loadErr
 	}
 
-	if csrString, loadErr = storage.LoadFile(caDir, commonName+csrExtension); loadErr == nil {
+	if csrString, loadErr = storage.LoadFileIn(c.location, caDir, storage.FileNameIn(c.location, storage.FileTypeCSR, commonName)); loadErr == nil {
 		csr, err := cert.LoadCSR(csrString)
 		if err != nil {
 			return err
@@ -256,7 +807,7 @@ func (c *CA) loadCA(commonName string) error {
 		caData.csr = csr
 	}
 
-	if certString, loadErr = storage.LoadFile(caDir, commonName+certExtension); loadErr == nil {
+	if certString, loadErr = storage.LoadFileIn(c.location, caDir, storage.FileNameIn(c.location, storage.FileTypeCertificate, commonName)); loadErr == nil {
 		cert, err := cert.LoadCert(certString)
 		if err != nil {
 			return err
@@ -265,7 +816,7 @@ func (c *CA) loadCA(commonName string) error {
 		caData.certificate = cert
 	}
 
-	if crlString, loadErr = storage.LoadFile(caDir, c.CommonName+crlExtension); loadErr == nil {
+	if crlString, loadErr = storage.LoadFileIn(c.location, caDir, storage.FileNameIn(c.location, storage.FileTypeCRL, c.CommonName)); loadErr == nil {
 		crl, err := cert.LoadCRL(crlString)
 		if err != nil {
 			return err
@@ -274,21 +825,106 @@ func (c *CA) loadCA(commonName string) error {
 		caData.crl = crl
 	}
 
+	if identityString, loadErr := storage.LoadFileIn(c.location, caDir, storage.FileNameIn(c.location, storage.FileTypeIdentity, commonName)); loadErr == nil {
+		if err := json.Unmarshal(identityString, &caData.identity); err != nil {
+			return err
+		}
+	} else if caData.certificate != nil {
+		// CA created without the Identity stored (e.g. imported)
+		caData.identity = identityFromCertificate(caData.certificate)
+	}
+
 	c.Data = caData
+	c.certPool.Store((*x509.CertPool)(nil))
 
 	return nil
 }
 
-func (c *CA) signCSR(csr x509.CertificateRequest, valid int) (certificate Certificate, err error) {
+// csrSubject returns the CSR subject with the common name. The country,
+// province, locality, organization and organizational unit missing in the CSR
+// are taken from the CA Identity, the CSR values have precedence.
+func (c *CA) csrSubject(commonName string, subject pkix.Name) pkix.Name {
+	id := c.Data.identity
+	subject.CommonName = commonName
+
+	fallback := func(values []string, value string) []string {
+		if len(values) == 0 && value != "" {
+			return []string{value}
+		}
+		return values
+	}
+	subject.Country = fallback(subject.Country, id.Country)
+	subject.Province = fallback(subject.Province, id.Province)
+	subject.Locality = fallback(subject.Locality, id.Locality)
+	subject.Organization = fallback(subject.Organization, id.Organization)
+	subject.OrganizationalUnit = fallback(subject.OrganizationalUnit, id.OrganizationalUnit)
+
+	return subject
+}
+
+// identityFromCertificate returns the Identity describing the certificate
+func identityFromCertificate(certificate *x509.Certificate) Identity {
+	id := Identity{
+		Organization:        firstOrEmpty(certificate.Subject.Organization),
+		OrganizationalUnit:  firstOrEmpty(certificate.Subject.OrganizationalUnit),
+		Country:             firstOrEmpty(certificate.Subject.Country),
+		Locality:            firstOrEmpty(certificate.Subject.Locality),
+		Province:            firstOrEmpty(certificate.Subject.Province),
+		EmailAddresses:      firstOrEmpty(certificate.EmailAddresses),
+		DNSNames:            certificate.DNSNames,
+		Intermediate:        !isSelfSigned(certificate),
+		Valid:               int(certificate.NotAfter.Sub(certificate.NotBefore).Hours() / 24),
+		PermittedDNSDomains: certificate.PermittedDNSDomains,
+		ExcludedDNSDomains:  certificate.ExcludedDNSDomains,
+	}
+
+	if certificate.MaxPathLen > 0 || certificate.MaxPathLenZero {
+		maxPathLen := certificate.MaxPathLen
+		id.MaxPathLen = &maxPathLen
+	}
+
+	switch publicKey := certificate.PublicKey.(type) {
+	case *rsa.PublicKey:
+		id.KeyAlgorithm = key.AlgorithmRSA
+		id.KeyBitSize = publicKey.N.BitLen()
+	case ed25519.PublicKey:
+		id.KeyAlgorithm = key.AlgorithmEd25519
+	case *ecdsa.PublicKey:
+		id.KeyAlgorithm = key.AlgorithmECDSAP256
+		if publicKey.Curve == elliptic.P384() {
+			id.KeyAlgorithm = key.AlgorithmECDSAP384
+		}
+	}
+
+	for _, policy := range certificate.PolicyIdentifiers {
+		id.PolicyOIDs = append(id.PolicyOIDs, asn1.ObjectIdentifier(policy))
+	}
+
+	return id
+}
+
+func (c *CA) signCSR(csr x509.CertificateRequest, opts cert.SignOptions) (certificate Certificate, err error) {
+
+	if err := validateCommonName(csr.Subject.CommonName); err != nil {
+		return certificate, err
+	}
+	if err := c.validateDomains(append([]string{csr.Subject.CommonName}, csr.DNSNames...)); err != nil {
+		return certificate, err
+	}
+
+	if c.Data.signer == nil {
+		return certificate, ErrCAMissingPrivateKey
+	}
 
 	certificate = Certificate{
 		commonName:    csr.Subject.CommonName,
 		csr:           csr,
 		caCertificate: c.Data.certificate,
 		CACertificate: c.Data.Certificate,
+		location:      c.location,
 	}
 
-	if csrString, err := storage.LoadFile(c.CommonName, "cert", certificate.commonName+csrExtension); err == nil {
+	if csrString, err := storage.LoadFileIn(c.location, c.CommonName, "cert", storage.FileNameIn(c.location, storage.FileTypeCSR, certificate.commonName)); err == nil {
 		_, err := cert.LoadCSR(csrString)
 		if err != nil {
 			return certificate, err
@@ -296,7 +932,9 @@ func (c *CA) signCSR(csr x509.CertificateRequest, valid int) (certificate Certif
 		certificate.CSR = string(csrString)
 	}
 
-	certBytes, err := cert.CASignCSR(c.CommonName, csr, c.Data.certificate, &c.Data.privateKey, valid, storage.CreationTypeCertificate)
+	opts.BrowserCompatible = c.BrowserCompatible
+	opts.Location = c.location
+	certBytes, err := c.signWithSerial(csr, opts)
 	if err != nil {
 		return certificate, err
 	}
@@ -316,13 +954,13 @@ func (c *CA) signCSR(csr x509.CertificateRequest, valid int) (certificate Certif
 
 	// if we are signing another CA, we need to make sure the certificate file also
 	// exists under the signed CA's $CAPATH directory, not just the signing CA's directory.
-	knownCAs := List()
+	knownCAs := listCAs(c.location)
 	for _, knownCA := range knownCAs {
 		if knownCA == certificate.commonName {
-			srcPath := filepath.Join(c.CommonName, "certs", certificate.commonName, certificate.commonName+certExtension)
-			destPath := filepath.Join(certificate.commonName, "ca", certificate.commonName+certExtension)
+			srcPath := filepath.Join(c.CommonName, "certs", certificate.commonName, storage.FileNameIn(c.location, storage.FileTypeCertificate, certificate.commonName))
+			destPath := filepath.Join(certificate.commonName, "ca", storage.FileNameIn(c.location, storage.FileTypeCertificate, certificate.commonName))
 
-			err = storage.CopyFile(srcPath, destPath)
+			err = storage.CopyFileIn(c.location, srcPath, destPath)
 			if err != nil {
 				return certificate, err
 			}
@@ -335,7 +973,20 @@ func (c *CA) signCSR(csr x509.CertificateRequest, valid int) (certificate Certif
 
 }
 
-func (c *CA) issueCertificate(commonName string, id Identity) (certificate Certificate, err error) {
+func (c *CA) issueCertificate(ctx context.Context, commonName string, id Identity) (certificate Certificate, err error) {
+	return c.issueCertificateFromTemplate(ctx, commonName, id, nil)
+}
+
+// issueCertificateFromTemplate issues the certificate with the fields of the
+// template, if not nil, overriding the defaults (cert.SignOptions.Template)
+func (c *CA) issueCertificateFromTemplate(ctx context.Context, commonName string, id Identity, template *x509.Certificate) (certificate Certificate, err error) {
+
+	if err := validateCommonName(commonName); err != nil {
+		return certificate, err
+	}
+	if err := c.validateDomains(append([]string{commonName}, id.DNSNames...)); err != nil {
+		return certificate, err
+	}
 
 	var (
 		caCertsDir      string = filepath.Join(c.CommonName, "certs")
@@ -344,43 +995,129 @@ func (c *CA) issueCertificate(commonName string, id Identity) (certificate Certi
 		csrString       []byte
 	)
 
+	if c.Data.signer == nil {
+		return certificate, ErrCAMissingPrivateKey
+	}
+
+	if c.IsFrozen() {
+		return certificate, ErrCAFrozen
+	}
+
+	// a failed or cancelled issuance removes the files already written, not
+	// to leave a certificate folder without certificate
+	certDir := filepath.Join(caCertsDir, commonName)
+	if !storage.ExistsIn(c.location, certDir) {
+		defer func() {
+			if err != nil {
+				storage.RemoveIn(c.location, certDir)
+			}
+		}()
+	}
+
 	certificate.CACertificate = c.Data.Certificate
 	certificate.caCertificate = c.Data.certificate
 
-	certKeys, err := key.CreateKeys(c.CommonName, commonName, storage.CreationTypeCertificate, id.KeyBitSize)
+	if c.DuplicateSANPolicy != DuplicateSANAllow {
+		conflict, err := c.duplicateSAN(commonName, append([]string{commonName}, id.DNSNames...))
+		if err != nil {
+			return certificate, err
+		}
+		if conflict != nil {
+			if c.DuplicateSANPolicy == DuplicateSANDeny {
+				return certificate, conflict
+			}
+			log.Printf("goca: issuing %s: %s", commonName, conflict.Error())
+		}
+	}
+
+	keyBitSize := id.KeyBitSize
+	if keyBitSize == 0 {
+		keyBitSize = c.DefaultKeyBitSize
+	}
+	if keyBitSize == 0 {
+		keyBitSize = key.DefaultKeyBitSize
+	}
+
+	keyOptions := key.KeyOptions{
+		Algorithm:  id.KeyAlgorithm,
+		BitSize:    keyBitSize,
+		Location:   c.location,
+		KeyEncoder: c.keyEncoder,
+	}
+	certKeys, err := key.CreateKeysContext(ctx, c.CommonName, commonName, storage.CreationTypeCertificate, keyOptions)
 	if err != nil {
 		return certificate, err
 	}
 
-	if keyString, err = storage.LoadFile(caCertsDir, commonName, "key.pem"); err != nil {
+	if keyString, err = storage.LoadKeyFileIn(c.location, c.keyDecoder, caCertsDir, commonName, storage.FileNameIn(c.location, storage.FileTypeKey, commonName)); err != nil {
 		keyString = []byte{}
 	}
 
-	if publicKeyString, err = storage.LoadFile(caCertsDir, commonName, "key.pub"); err != nil {
+	if publicKeyString, err = storage.LoadFileIn(c.location, caCertsDir, commonName, storage.FileNameIn(c.location, storage.FileTypePublicKey, commonName)); err != nil {
 		publicKeyString = []byte{}
 	}
 
-	privKey := &certKeys.Key
-	pubKey := &certKeys.PublicKey
+	privKey := certKeys.Signer
 
-	certificate.privateKey = *privKey
+	certificate.privateKey = certKeys.Key
+	certificate.signer = certKeys.Signer
 	certificate.PrivateKey = string(keyString)
-	certificate.publicKey = *pubKey
+	certificate.publicKey = certKeys.PublicKey
+	certificate.public = certKeys.Public
 	certificate.PublicKey = string(publicKeyString)
 
-	csrBytes, err := cert.CreateCSR(c.CommonName, commonName, id.Country, id.Province, id.Locality, id.Organization, id.OrganizationalUnit, id.EmailAddresses, id.DNSNames, privKey, storage.CreationTypeCertificate)
+	if err := ctx.Err(); err != nil {
+		return certificate, err
+	}
+
+	csrOptions := cert.CSROptions{
+		SubjectSerialNumber: id.SubjectSerialNumber,
+		Location:            c.location,
+		IPAddresses:         id.IPAddresses,
+	}
+	csrBytes, err := cert.CreateCSRWithOptions(c.CommonName, commonName, id.Country, id.Province, id.Locality, id.Organization, id.OrganizationalUnit, id.EmailAddresses, id.DNSNames, id.EmailPlacement, privKey, csrOptions, storage.CreationTypeCertificate)
 	if err != nil {
 		return certificate, err
 	}
 
 	csr, _ := x509.ParseCertificateRequest(csrBytes)
-	if csrString, err = storage.LoadFile(caCertsDir, commonName, commonName+csrExtension); err != nil {
+	if csrString, err = storage.LoadFileIn(c.location, caCertsDir, commonName, storage.FileNameIn(c.location, storage.FileTypeCSR, commonName)); err != nil {
 		csrString = []byte{}
 	}
 
 	certificate.csr = *csr
 	certificate.CSR = string(csrString)
-	certBytes, err := cert.CASignCSR(c.CommonName, *csr, c.Data.certificate, &c.Data.privateKey, id.Valid, storage.CreationTypeCertificate)
+	certificate.location = c.location
+	signOptions := cert.SignOptions{
+		Valid:          id.Valid,
+		EmailPlacement: id.EmailPlacement,
+		PolicyOIDs:     id.PolicyOIDs,
+		CPSURIs:        id.CPSURIs,
+		UPNs:           id.UPNs,
+		OCSPNoCheck:    id.OCSPNoCheck,
+		KeepDNSNames:   id.KeepDNSNames,
+		ValidityJitter: time.Duration(id.ValidityJitter) * time.Hour,
+		OCSPServers:    id.OCSPServers,
+		CAIssuersURLs:  id.CAIssuersURLs,
+		CRLURLs:        id.CRLURLs,
+		KeyUsage:       id.KeyUsage,
+		ExtKeyUsage:    id.ExtKeyUsage,
+		Issuer:         id.Issuer,
+		Template:       template,
+
+		BrowserCompatible: c.BrowserCompatible,
+		Location:          c.location,
+	}
+	if template != nil && !template.NotAfter.IsZero() {
+		signOptions.NotBefore, signOptions.NotAfter = template.NotBefore, template.NotAfter
+		if signOptions.NotBefore.IsZero() {
+			signOptions.NotBefore = time.Now()
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return certificate, err
+	}
+	certBytes, err := c.signWithSerial(*csr, signOptions)
 	if err != nil {
 		return certificate, err
 	}
@@ -398,12 +1135,93 @@ func (c *CA) issueCertificate(commonName string, id Identity) (certificate Certi
 
 	certificate.certificate = cert
 
-	return certificate, nil
-
-}
+	if id.EmbedChain {
+		if err := c.saveEmbeddedChain(&certificate, commonName, certBytes, id.EmbedRoot); err != nil {
+			return certificate, err
+		}
+	}
+
+	return certificate, nil
+
+}
+
+// loadCertificateMeta loads only the certificate, skipping the keys and CSR, for
+// the read-only paths. It returns nil if the certificate file does not exist.
+func (c *CA) loadCertificateMeta(commonName string) (*x509.Certificate, error) {
+
+	if err := validateCommonName(commonName); err != nil {
+		return nil, err
+	}
+
+	caCertsDir := filepath.Join(c.CommonName, "certs", commonName)
+
+	if !storage.ExistsIn(c.location, caCertsDir) {
+		return nil, ErrCertLoadNotFound
+	}
+
+	certString, loadErr := storage.LoadFileIn(c.location, caCertsDir, storage.FileNameIn(c.location, storage.FileTypeCertificate, commonName))
+	if loadErr != nil {
+		return nil, nil
+	}
+
+	return cert.LoadCert(certString)
+}
+
+// parseCertificate parses the PEM encoded certificate without CA or $CAPATH.
+// With chain, the following certificates are the CA certificate chain,
+// otherwise more than one certificate is rejected.
+func parseCertificate(pemBytes []byte, chain bool) (certificate Certificate, err error) {
+	var certificates []*x509.Certificate
+	for rest := pemBytes; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		parsed, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return Certificate{}, err
+		}
+		certificates = append(certificates, parsed)
+	}
+
+	if len(certificates) == 0 {
+		return Certificate{}, ErrCertPEMInvalid
+	}
+	if len(certificates) > 1 && !chain {
+		return Certificate{}, ErrCertPEMMultiple
+	}
+
+	certificate = Certificate{
+		commonName:  certificates[0].Subject.CommonName,
+		Certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificates[0].Raw})),
+		certificate: certificates[0],
+		public:      certificates[0].PublicKey,
+	}
+	if rsaPublicKey, ok := certificates[0].PublicKey.(*rsa.PublicKey); ok {
+		certificate.publicKey = *rsaPublicKey
+	}
+
+	if len(certificates) > 1 {
+		certificate.caCertificate = certificates[1]
+		for _, caCertificate := range certificates[1:] {
+			certificate.CACertificate += string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCertificate.Raw}))
+		}
+	}
+
+	return certificate, nil
+}
 
 func (c *CA) loadCertificate(commonName string) (certificate Certificate, err error) {
 
+	if err := validateCommonName(commonName); err != nil {
+		return certificate, err
+	}
+
 	var (
 		caCertsDir      string = filepath.Join(c.CommonName, "certs", commonName)
 		keyString       []byte
@@ -413,32 +1231,48 @@ func (c *CA) loadCertificate(commonName string) (certificate Certificate, err er
 		loadErr         error
 	)
 
-	if _, err := os.Stat(filepath.Join(os.Getenv("CAPATH"), caCertsDir)); errors.Is(err, fs.ErrNotExist) {
+	if !storage.ExistsIn(c.location, caCertsDir) {
 		return certificate, ErrCertLoadNotFound
 	}
 
 	certificate.CACertificate = c.Data.Certificate
 	certificate.caCertificate = c.Data.certificate
+	certificate.location = c.location
 
-	if keyString, loadErr = storage.LoadFile(caCertsDir, "key.pem"); loadErr == nil {
-		privateKey, _ := key.LoadPrivateKey(keyString)
+	if keyString, loadErr = storage.LoadKeyFileIn(c.location, c.keyDecoder, caCertsDir, storage.FileNameIn(c.location, storage.FileTypeKey, commonName)); loadErr == nil {
+		privateKey, err := key.LoadSigner(keyString)
+		if err != nil {
+			return certificate, err
+		}
 		certificate.PrivateKey = string(keyString)
-		certificate.privateKey = *privateKey
+		certificate.signer = privateKey
+		if rsaPrivateKey, ok := privateKey.(*rsa.PrivateKey); ok {
+			certificate.privateKey = *rsaPrivateKey
+		}
 	}
 
-	if publicKeyString, loadErr = storage.LoadFile(caCertsDir, "key.pub"); loadErr == nil {
-		publicKey, _ := key.LoadPublicKey(publicKeyString)
+	if publicKeyString, loadErr = storage.LoadFileIn(c.location, caCertsDir, storage.FileNameIn(c.location, storage.FileTypePublicKey, commonName)); loadErr == nil {
+		publicKey, err := key.LoadPublic(publicKeyString)
+		if err != nil {
+			return certificate, err
+		}
 		certificate.PublicKey = string(publicKeyString)
-		certificate.publicKey = *publicKey
+		certificate.public = publicKey
+		if rsaPublicKey, ok := publicKey.(*rsa.PublicKey); ok {
+			certificate.publicKey = *rsaPublicKey
+		}
 	}
 
-	if csrString, loadErr = storage.LoadFile(caCertsDir, commonName+csrExtension); loadErr == nil {
-		csr, _ := cert.LoadCSR(csrString)
+	if csrString, loadErr = storage.LoadFileIn(c.location, caCertsDir, storage.FileNameIn(c.location, storage.FileTypeCSR, commonName)); loadErr == nil {
+		csr, err := cert.LoadCSR(csrString)
+		if err != nil {
+			return certificate, err
+		}
 		certificate.CSR = string(csrString)
 		certificate.csr = *csr
 	}
 
-	if certString, loadErr = storage.LoadFile(caCertsDir, commonName+certExtension); loadErr == nil {
+	if certString, loadErr = storage.LoadFileIn(c.location, caCertsDir, storage.FileNameIn(c.location, storage.FileTypeCertificate, commonName)); loadErr == nil {
 		cert, err := cert.LoadCert(certString)
 		if err != nil {
 			return certificate, err
@@ -451,15 +1285,27 @@ func (c *CA) loadCertificate(commonName string) (certificate Certificate, err er
 }
 
 func (c *CA) revokeCertificate(certificate *x509.Certificate) error {
+	return c.revokeSerial(certificate.SerialNumber, RevocationReasonUnspecified)
+}
+
+func (c *CA) revokeSerial(serialNumber *big.Int, reason int) error {
 
 	var revokedCerts []pkix.RevokedCertificate
-	var caDir string = filepath.Join(c.CommonName, "ca")
-	var crlString []byte
+
+	if reason < RevocationReasonUnspecified || reason > RevocationReasonAACompromise || reason == 7 {
+		return ErrInvalidRevocationReason
+	}
+
+	unlock, err := c.lockCRL()
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	currentCRL := c.GoCRL()
 	if currentCRL != nil {
-		for _, serialNumber := range currentCRL.TBSCertList.RevokedCertificates {
-			if serialNumber.SerialNumber.String() == certificate.SerialNumber.String() {
+		for _, revoked := range currentCRL.TBSCertList.RevokedCertificates {
+			if revoked.SerialNumber.Cmp(serialNumber) == 0 {
 				return ErrCertRevoked
 			}
 		}
@@ -468,28 +1314,1640 @@ func (c *CA) revokeCertificate(certificate *x509.Certificate) error {
 	}
 
 	newCertRevoke := pkix.RevokedCertificate{
-		SerialNumber:   certificate.SerialNumber,
+		SerialNumber:   serialNumber,
 		RevocationTime: time.Now(),
 	}
 
+	// the reason code unspecified should not be used (RFC 5280 section 5.3.1)
+	if reason != RevocationReasonUnspecified {
+		reasonCode, err := asn1.Marshal(asn1.Enumerated(reason))
+		if err != nil {
+			return err
+		}
+		newCertRevoke.Extensions = []pkix.Extension{
+			{Id: oidCRLReasonCode, Value: reasonCode},
+		}
+	}
+
 	revokedCerts = append(revokedCerts, newCertRevoke)
 
-	crlByte, err := cert.RevokeCertificate(c.CommonName, revokedCerts, c.Data.certificate, &c.Data.privateKey)
+	_, err = c.updateCRL(revokedCerts, 0)
+
+	return err
+}
+
+func (c *CA) unrevokeCertificate(certificate *x509.Certificate) error {
+
+	var revokedCerts []pkix.RevokedCertificate
+
+	unlock, err := c.lockCRL()
 	if err != nil {
 		return err
 	}
+	defer unlock()
 
-	crl, err := x509.ParseCRL(crlByte)
+	currentCRL := c.GoCRL()
+	if currentCRL == nil {
+		return ErrCertNotRevoked
+	}
+
+	found := false
+	for _, revoked := range currentCRL.TBSCertList.RevokedCertificates {
+		if revoked.SerialNumber.Cmp(certificate.SerialNumber) == 0 {
+			found = true
+			continue
+		}
+		revokedCerts = append(revokedCerts, revoked)
+	}
+	if !found {
+		return ErrCertNotRevoked
+	}
+
+	_, err = c.updateCRL(revokedCerts, 0)
+
+	return err
+}
+
+func (c *CA) refreshCRL(validity time.Duration) ([]byte, error) {
+
+	if c.Data.signer == nil {
+		return nil, ErrCAMissingPrivateKey
+	}
+
+	var revokedCerts []pkix.RevokedCertificate
+
+	unlock, err := c.lockCRL()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	currentCRL := c.GoCRL()
+	if currentCRL != nil {
+		revokedCerts = currentCRL.TBSCertList.RevokedCertificates
+	}
+
+	return c.updateCRL(revokedCerts, validity)
+}
+
+func (c *CA) compactCRL() (removed int, err error) {
+
+	if c.Data.signer == nil {
+		return 0, ErrCAMissingPrivateKey
+	}
+
+	unlock, err := c.lockCRL()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	currentCRL := c.GoCRL()
+	if currentCRL == nil {
+		return 0, nil
+	}
+
+	certificates := make(map[string]*x509.Certificate)
+	for _, commonName := range c.ListCertificates() {
+		certificate, err := c.loadCertificateMeta(commonName)
+		if err != nil {
+			return 0, err
+		}
+		if certificate != nil {
+			certificates[certificate.SerialNumber.String()] = certificate
+		}
+	}
+
+	now := time.Now()
+	var revokedCerts []pkix.RevokedCertificate
+	for _, revoked := range currentCRL.TBSCertList.RevokedCertificates {
+		// unknown certificates are kept
+		certificate, ok := certificates[revoked.SerialNumber.String()]
+		if ok && now.After(certificate.NotAfter) {
+			removed++
+			continue
+		}
+		revokedCerts = append(revokedCerts, revoked)
+	}
+
+	if removed == 0 {
+		return 0, nil
+	}
+
+	if _, err := c.updateCRL(revokedCerts, 0); err != nil {
+		return 0, err
+	}
+
+	return removed, nil
+}
+
+// lockCRL locks the CRL updates of the CA folder, returning the function
+// unlocking them. The stored CRL is loaded again, as it may be updated by
+// another copy of the CA.
+func (c *CA) lockCRL() (unlock func(), err error) {
+	lock, _ := crlLocks.LoadOrStore(filepath.Join(c.caPath(), c.CommonName), &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+
+	if err := c.reloadCRL(); err != nil {
+		lock.(*sync.Mutex).Unlock()
+		return nil, err
+	}
+
+	return lock.(*sync.Mutex).Unlock, nil
+}
+
+// reloadCRL loads the stored CRL again, as it may be updated by another copy
+// of the CA or another process. Without a stored CRL the current CRL is kept.
+func (c *CA) reloadCRL() error {
+	crlFile := storage.FileNameIn(c.location, storage.FileTypeCRL, c.CommonName)
+
+	modTime, err := storage.LatestModTimeIn(c.location, c.CommonName, "ca", crlFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	crlString, err := storage.LoadFileIn(c.location, c.CommonName, "ca", crlFile)
+	if err != nil {
+		return err
+	}
+	crl, err := cert.LoadCRL(crlString)
 	if err != nil {
 		return err
 	}
+
+	state := c.crlState()
+	state.Lock()
+	c.Data.CRL = string(crlString)
 	c.Data.crl = crl
+	c.crlModTime = modTime
+	state.Unlock()
+
+	return nil
+}
+
+// crlState returns the lock of the loaded CRL of the CA folder
+func (c *CA) crlState() *sync.RWMutex {
+	lock, _ := crlStateLocks.LoadOrStore(filepath.Join(c.caPath(), c.CommonName), &sync.RWMutex{})
+
+	return lock.(*sync.RWMutex)
+}
+
+// reloadStaleCRL reloads the CRL if the stored CRL was modified after it was
+// loaded, with WithCRLAutoReload. On errors the current CRL is kept.
+func (c *CA) reloadStaleCRL() error {
+	if !c.crlAutoReload {
+		return nil
+	}
+
+	modTime, err := storage.LatestModTimeIn(c.location, c.CommonName, "ca", storage.FileNameIn(c.location, storage.FileTypeCRL, c.CommonName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	state := c.crlState()
+	state.RLock()
+	stale := modTime.After(c.crlModTime)
+	state.RUnlock()
+
+	if !stale {
+		return nil
+	}
+
+	return c.reloadCRL()
+}
+
+// loadedCRL returns the loaded CRL, reloaded first if stale with
+// WithCRLAutoReload. The reload errors are logged and keep the current CRL.
+func (c *CA) loadedCRL() (crlString string, crl *pkix.CertificateList) {
+	if err := c.reloadStaleCRL(); err != nil {
+		log.Printf("goca: reloading the CRL of %s: %s", c.CommonName, err.Error())
+	}
+
+	state := c.crlState()
+	state.RLock()
+	defer state.RUnlock()
+
+	return c.Data.CRL, c.Data.crl
+}
 
-	if crlString, err = storage.LoadFile(caDir, c.CommonName+crlExtension); err != nil {
+// crlNumber returns the CRL number of the current CRL, nil if not available
+func (c *CA) crlNumber() *big.Int {
+	currentCRL := c.GoCRL()
+	if currentCRL == nil {
+		return nil
+	}
+
+	for _, extension := range currentCRL.TBSCertList.Extensions {
+		if extension.Id.Equal(oidCRLNumber) {
+			number := new(big.Int)
+			if _, err := asn1.Unmarshal(extension.Value, &number); err != nil {
+				return nil
+			}
+			return number
+		}
+	}
+
+	return nil
+}
+
+// crlSigner checks if the CA certificate is allowed to sign CRLs. Without the
+// key usage extension all the usages are allowed (RFC 5280).
+func crlSigner(certificate *x509.Certificate) bool {
+	return certificate.KeyUsage == 0 || certificate.KeyUsage&x509.KeyUsageCRLSign != 0
+}
+
+// updateCRL generates and stores a new CRL with the revoked certificates and
+// the next CRL number, returning the DER encoded CRL. The validity 0 is the
+// CA CRL validity.
+func (c *CA) updateCRL(revokedCerts []pkix.RevokedCertificate, validity time.Duration) ([]byte, error) {
+
+	var caDir string = filepath.Join(c.CommonName, "ca")
+	var crlString []byte
+
+	if c.Data.certificate != nil && !crlSigner(c.Data.certificate) {
+		return nil, ErrCANotCRLSigner
+	}
+
+	if validity == 0 {
+		validity = c.crlValidity
+	}
+
+	number := big.NewInt(1)
+	if current := c.crlNumber(); current != nil {
+		number.Add(current, big.NewInt(1))
+	}
+
+	// the CRL PEM is stored in ca/<CA Common Name>.crl, so the revocations
+	// survive loading the CA again
+	crlByte, err := cert.RevokeCertificateWithOptions(c.CommonName, revokedCerts, c.Data.certificate, c.Data.signer, cert.CRLOptions{Number: number, Validity: validity, Location: c.location})
+	if err != nil {
+		return nil, err
+	}
+
+	crl, err := x509.ParseCRL(crlByte)
+	if err != nil {
+		return nil, err
+	}
+
+	if crlString, err = storage.LoadFileIn(c.location, caDir, storage.FileNameIn(c.location, storage.FileTypeCRL, c.CommonName)); err != nil {
 		crlString = []byte{}
 	}
 
+	state := c.crlState()
+	state.Lock()
+	c.Data.crl = crl
 	c.Data.CRL = string(crlString)
+	state.Unlock()
+
+	return crlByte, nil
+}
+
+func (c *CA) verifyCertificate(certificate *x509.Certificate) error {
+	if certificate == nil {
+		return ErrCertInvalid
+	}
+	if c.Data.certificate == nil {
+		return ErrCertUntrusted
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         x509.NewCertPool(),
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	for _, caCertificate := range caCertificateChain(c.location, c.Data.certificate) {
+		if isSelfSigned(caCertificate) {
+			opts.Roots.AddCert(caCertificate)
+		} else {
+			opts.Intermediates.AddCert(caCertificate)
+		}
+	}
+
+	if _, err := certificate.Verify(opts); err != nil {
+		var invalidErr x509.CertificateInvalidError
+		if errors.As(err, &invalidErr) && invalidErr.Reason == x509.Expired {
+			return ErrCertExpired
+		}
+		if errors.As(err, &invalidErr) && invalidErr.Reason == x509.CANotAuthorizedForThisName {
+			return ErrCertNameConstraints
+		}
+		var authorityErr x509.UnknownAuthorityError
+		if errors.As(err, &authorityErr) {
+			return ErrCertUntrusted
+		}
+		return err
+	}
+
+	// a certificate issued by a parent CA is trusted, but not issued by the CA
+	if certificate.CheckSignatureFrom(c.Data.certificate) != nil {
+		return ErrCertUntrusted
+	}
+
+	if c.isRevoked(certificate) {
+		return ErrCertRevoked
+	}
 
 	return nil
 }
+
+func (c *CA) deleteCertificate(commonName string) error {
+	certificate, err := c.loadCertificateMeta(commonName)
+	if err != nil {
+		return err
+	}
+
+	// a certificate already revoked is only removed
+	if certificate != nil && !c.isRevoked(certificate) {
+		if err := c.revokeCertificate(certificate); err != nil {
+			return err
+		}
+	}
+
+	return storage.RemoveIn(c.location, c.CommonName, "certs", commonName)
+}
+
+func (c *CA) isRevoked(certificate *x509.Certificate) bool {
+	currentCRL := c.GoCRL()
+	if currentCRL == nil {
+		return false
+	}
+
+	for _, revoked := range currentCRL.TBSCertList.RevokedCertificates {
+		if revoked.SerialNumber.Cmp(certificate.SerialNumber) == 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *CA) certificateStatus(certificate *x509.Certificate) CertStatus {
+	if c.isRevoked(certificate) {
+		return CertStatusRevoked
+	}
+
+	if time.Now().After(certificate.NotAfter) {
+		return CertStatusExpired
+	}
+
+	return CertStatusActive
+}
+
+func (c *CA) listCertificatesFiltered(prefix string) []string {
+	var certificates []string
+
+	for _, commonName := range c.ListCertificates() {
+		if strings.HasPrefix(commonName, prefix) {
+			certificates = append(certificates, commonName)
+		}
+	}
+
+	return certificates
+}
+
+func (c *CA) listCertificatesInfo(prefix string) ([]CertificateInfo, error) {
+
+	var certificates []CertificateInfo
+
+	// the CRL is read once for all the certificates
+	revokedSerials := make(map[string]bool)
+	if currentCRL := c.GoCRL(); currentCRL != nil {
+		for _, revoked := range currentCRL.TBSCertList.RevokedCertificates {
+			revokedSerials[revoked.SerialNumber.String()] = true
+		}
+	}
+
+	for _, commonName := range c.listCertificatesFiltered(prefix) {
+		certificate, err := c.loadCertificateMeta(commonName)
+		if err != nil {
+			return nil, err
+		}
+
+		if certificate == nil {
+			continue
+		}
+
+		certificates = append(certificates, CertificateInfo{
+			CommonName: commonName,
+			NotAfter:   certificate.NotAfter,
+			Revoked:    revokedSerials[certificate.SerialNumber.String()],
+		})
+	}
+
+	return certificates, nil
+}
+
+func (c *CA) listExpiringCertificates(within time.Duration) ([]Certificate, error) {
+
+	var (
+		certificates []Certificate
+		corrupt      []string
+	)
+
+	deadline := time.Now().Add(within)
+
+	for _, commonName := range c.ListCertificates() {
+		certificate, err := c.loadCertificateMeta(commonName)
+		if err == nil && certificate == nil && storage.ExistsIn(c.location, c.CommonName, "certs", commonName, storage.FileNameIn(c.location, storage.FileTypeCertificate, commonName)) {
+			err = ErrCertInvalid
+		}
+		if err != nil {
+			corrupt = append(corrupt, commonName)
+			continue
+		}
+
+		if certificate == nil || c.isRevoked(certificate) || certificate.NotAfter.After(deadline) {
+			continue
+		}
+
+		expiring, err := c.loadCertificate(commonName)
+		if err != nil {
+			corrupt = append(corrupt, commonName)
+			continue
+		}
+		certificates = append(certificates, expiring)
+	}
+
+	if len(corrupt) > 0 {
+		return certificates, &CorruptCertificatesError{CommonNames: corrupt}
+	}
+
+	return certificates, nil
+}
+
+func (c *CA) listCertificatesByStatus(status CertStatus) ([]string, error) {
+
+	var certificates []string
+
+	for _, commonName := range c.ListCertificates() {
+		certificate, err := c.loadCertificateMeta(commonName)
+		if err != nil {
+			return nil, err
+		}
+
+		if certificate == nil {
+			continue
+		}
+
+		if c.certificateStatus(certificate) == status {
+			certificates = append(certificates, commonName)
+		}
+	}
+
+	return certificates, nil
+}
+
+// certificatePool returns the cached CertPool with the CA Certificate. The
+// CertPool is stored atomically as Verify may be called concurrently.
+func (c *CA) certificatePool() *x509.CertPool {
+	if certPool, _ := c.certPool.Load().(*x509.CertPool); certPool != nil {
+		return certPool
+	}
+
+	certPool := x509.NewCertPool()
+	if c.Data.certificate != nil {
+		certPool.AddCert(c.Data.certificate)
+	}
+	c.certPool.Store(certPool)
+
+	return certPool
+}
+
+// caCertificatePool returns a new CertPool with the CA Certificate and the
+// parent CA certificates up to the root CA certificate
+func (c *CA) caCertificatePool() *x509.CertPool {
+	certPool := x509.NewCertPool()
+	for _, caCertificate := range caCertificateChain(c.location, c.Data.certificate) {
+		certPool.AddCert(caCertificate)
+	}
+
+	return certPool
+}
+
+func (c *CA) verify(certificate *x509.Certificate) error {
+	return c.verifyWithPool(certificate, c.certificatePool())
+}
+
+func (c *CA) verifyWithPool(certificate *x509.Certificate, certPool *x509.CertPool) error {
+	if certificate == nil {
+		return ErrCertInvalid
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:     certPool,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+
+	if _, err := certificate.Verify(opts); err != nil {
+		return err
+	}
+
+	if c.isRevoked(certificate) {
+		return ErrCertRevoked
+	}
+
+	return nil
+}
+
+func (c *CA) verifyAll(workers int) map[string]error {
+
+	var (
+		results   = make(map[string]error)
+		resultsMu sync.Mutex
+		wg        sync.WaitGroup
+		jobs      = make(chan string)
+	)
+
+	// the CertPool is built once and shared by all workers
+	certPool := c.certificatePool()
+
+	// the revocation status is unknown with a CRL failing to reload
+	if err := c.reloadStaleCRL(); err != nil {
+		for _, commonName := range c.ListCertificates() {
+			results[commonName] = err
+		}
+		return results
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for commonName := range jobs {
+				var err error
+				certificate, loadErr := c.loadCertificateMeta(commonName)
+				if loadErr != nil {
+					err = loadErr
+				} else {
+					err = c.verifyWithPool(certificate, certPool)
+				}
+
+				resultsMu.Lock()
+				results[commonName] = err
+				resultsMu.Unlock()
+			}
+		}()
+	}
+
+	for _, commonName := range c.ListCertificates() {
+		jobs <- commonName
+	}
+	close(jobs)
+
+	wg.Wait()
+
+	return results
+}
+
+func (c *CA) setCertificateMetadata(commonName string, meta map[string]string) error {
+	if err := validateCommonName(commonName); err != nil {
+		return err
+	}
+
+	if !storage.ExistsIn(c.location, c.CommonName, "certs", commonName) {
+		return ErrCertLoadNotFound
+	}
+
+	metaBytes, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	return storage.SaveFile(storage.File{
+		CA:           c.CommonName,
+		CommonName:   commonName,
+		FileType:     storage.FileTypeMetadata,
+		MetadataData: metaBytes,
+		CreationType: storage.CreationTypeCertificate,
+		Location:     c.location,
+	})
+}
+
+func (c *CA) certificateMetadata(commonName string) (meta map[string]string, err error) {
+	if err := validateCommonName(commonName); err != nil {
+		return nil, err
+	}
+
+	certDir := filepath.Join(c.CommonName, "certs", commonName)
+	if !storage.ExistsIn(c.location, certDir) {
+		return nil, ErrCertLoadNotFound
+	}
+
+	metaBytes, err := storage.LoadFileIn(c.location, certDir, storage.FileNameIn(c.location, storage.FileTypeMetadata, commonName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return map[string]string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(metaBytes, &meta)
+
+	return meta, err
+}
+
+func validateCSR(csr *x509.CertificateRequest, policy CSRPolicy) error {
+	if csr == nil {
+		return ErrCSRMissing
+	}
+
+	if err := csr.CheckSignature(); err != nil {
+		return ErrCSRInvalidSignature
+	}
+
+	for _, required := range policy.RequiredDNSNames {
+		found := false
+		for _, dnsName := range csr.DNSNames {
+			if strings.EqualFold(strings.TrimSpace(dnsName), required) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return ErrCSRMissingDNSName
+		}
+	}
+
+	if len(policy.ForbiddenExtKeyUsages) > 0 {
+		for _, extension := range append(csr.Extensions, csr.ExtraExtensions...) {
+			if !extension.Id.Equal(oidExtKeyUsage) {
+				continue
+			}
+
+			var extKeyUsages []asn1.ObjectIdentifier
+			if _, err := asn1.Unmarshal(extension.Value, &extKeyUsages); err != nil {
+				return err
+			}
+			for _, extKeyUsage := range extKeyUsages {
+				for _, forbidden := range policy.ForbiddenExtKeyUsages {
+					if extKeyUsage.Equal(forbidden) {
+						return ErrCSRForbiddenExtKeyUsage
+					}
+				}
+			}
+		}
+	}
+
+	minRSAKeyBitSize := policy.MinRSAKeyBitSize
+	if minRSAKeyBitSize == 0 {
+		minRSAKeyBitSize = MinRSAKeyBitSize
+	}
+	if publicKey, ok := csr.PublicKey.(*rsa.PublicKey); ok && publicKey.N.BitLen() < minRSAKeyBitSize {
+		return ErrCSRWeakKey
+	}
+
+	return nil
+}
+
+// duplicateSAN returns a *DuplicateSANError for the first domain covered by
+// another active certificate, nil if none.
+func (c *CA) duplicateSAN(commonName string, domains []string) (*DuplicateSANError, error) {
+
+	var conflict *DuplicateSANError
+
+	for _, issued := range c.ListCertificates() {
+		// re-issuing the same certificate is not a duplicate
+		if issued == commonName {
+			continue
+		}
+
+		certificate, err := c.loadCertificateMeta(issued)
+		if err != nil {
+			return nil, err
+		}
+
+		if certificate == nil || c.certificateStatus(certificate) != CertStatusActive {
+			continue
+		}
+
+		for _, domain := range domains {
+			if certificate.VerifyHostname(domain) != nil {
+				continue
+			}
+			if conflict == nil {
+				conflict = &DuplicateSANError{Domain: domain}
+			}
+			if conflict.Domain == domain {
+				conflict.CommonNames = append(conflict.CommonNames, issued)
+			}
+		}
+	}
+
+	return conflict, nil
+}
+
+func (c *CA) ocspRequest(commonName string) (*ocsp.Request, error) {
+	certificate, err := c.loadCertificateMeta(commonName)
+	if err != nil {
+		return nil, err
+	}
+
+	if certificate == nil {
+		return nil, ErrCertLoadNotFound
+	}
+
+	if c.Data.certificate == nil {
+		return nil, ErrCertInvalid
+	}
+
+	requestBytes, err := ocsp.CreateRequest(certificate, c.Data.certificate, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return ocsp.ParseRequest(requestBytes)
+}
+
+func (c *CA) ocspResponse(serial *big.Int) ([]byte, error) {
+	if c.Data.signer == nil {
+		return nil, ErrCAMissingPrivateKey
+	}
+	if serial == nil || c.Data.certificate == nil {
+		return nil, ErrCertInvalid
+	}
+
+	template := ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: serial,
+		ThisUpdate:   time.Now(),
+	}
+
+	revoked := false
+	if currentCRL := c.GoCRL(); currentCRL != nil {
+		template.NextUpdate = currentCRL.TBSCertList.NextUpdate
+		for _, revokedCertificate := range currentCRL.TBSCertList.RevokedCertificates {
+			if revokedCertificate.SerialNumber.Cmp(serial) == 0 {
+				template.Status = ocsp.Revoked
+				template.RevokedAt = revokedCertificate.RevocationTime
+				template.RevocationReason = revocationReason(revokedCertificate)
+				revoked = true
+				break
+			}
+		}
+	}
+
+	// a serial number not issued by the CA is unknown
+	if !revoked {
+		serials, err := c.issuedSerials()
+		if err != nil {
+			return nil, err
+		}
+		template.Status = ocsp.Unknown
+		for _, issued := range serials {
+			if issued == strings.ToUpper(serial.Text(16)) {
+				template.Status = ocsp.Good
+				break
+			}
+		}
+	}
+
+	return ocsp.CreateResponse(c.Data.certificate, c.Data.certificate, template, c.Data.signer)
+}
+
+// revocationReason returns the CRL entry reason code, unspecified if none
+func revocationReason(revokedCertificate pkix.RevokedCertificate) int {
+	for _, extension := range revokedCertificate.Extensions {
+		if extension.Id.Equal(oidCRLReasonCode) {
+			var reason asn1.Enumerated
+			if _, err := asn1.Unmarshal(extension.Value, &reason); err == nil {
+				return int(reason)
+			}
+		}
+	}
+
+	return RevocationReasonUnspecified
+}
+
+// ocspHandler is the OCSP responder (RFC 6960) HTTP handler of a CA
+type ocspHandler struct {
+	ca     *CA
+	prefix string // Path the handler is mounted at
+}
+
+func (h ocspHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var requestBytes []byte
+	var err error
+
+	switch r.Method {
+	case http.MethodGet:
+		// the URL encoded base64 request is the path after the handler prefix,
+		// the base64 "/" may be left unescaped (RFC 6960 A.1)
+		var encoded string
+		escapedPath := strings.TrimPrefix(r.URL.EscapedPath(), strings.TrimSuffix(h.prefix, "/"))
+		encoded, err = url.PathUnescape(strings.TrimPrefix(escapedPath, "/"))
+		if err == nil {
+			requestBytes, err = base64.StdEncoding.DecodeString(encoded)
+		}
+	case http.MethodPost:
+		requestBytes, err = io.ReadAll(io.LimitReader(r.Body, maxOCSPRequestSize))
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/ocsp-response")
+
+	if err != nil {
+		w.Write(ocsp.MalformedRequestErrorResponse)
+		return
+	}
+	request, err := ocsp.ParseRequest(requestBytes)
+	if err != nil {
+		w.Write(ocsp.MalformedRequestErrorResponse)
+		return
+	}
+	if !h.ca.ocspIssuer(request) {
+		w.Write(ocsp.UnauthorizedErrorResponse)
+		return
+	}
+
+	response, err := h.ca.ocspResponse(request.SerialNumber)
+	if err != nil {
+		w.Write(ocsp.InternalErrorErrorResponse)
+		return
+	}
+
+	w.Write(response)
+}
+
+// ocspIssuer returns if the OCSP request issuer key hash is the CA public key
+// hash
+func (c *CA) ocspIssuer(request *ocsp.Request) bool {
+	if c.Data.certificate == nil || !request.HashAlgorithm.Available() {
+		return false
+	}
+
+	var publicKeyInfo struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(c.Data.certificate.RawSubjectPublicKeyInfo, &publicKeyInfo); err != nil {
+		return false
+	}
+
+	hash := request.HashAlgorithm.New()
+	hash.Write(publicKeyInfo.PublicKey.RightAlign())
+
+	return bytes.Equal(hash.Sum(nil), request.IssuerKeyHash)
+}
+
+func (c *CA) certificatesForDomain(domain string) ([]string, error) {
+
+	var certificates []string
+
+	for _, commonName := range c.ListCertificates() {
+		certificate, err := c.loadCertificateMeta(commonName)
+		if err != nil {
+			return nil, err
+		}
+
+		if certificate == nil {
+			continue
+		}
+
+		// x509 hostname matching rules, including wildcards
+		if certificate.VerifyHostname(domain) == nil {
+			certificates = append(certificates, commonName)
+		}
+	}
+
+	return certificates, nil
+}
+
+func (c *CA) certificatesIssuedBetween(start, end time.Time) ([]string, error) {
+
+	var certificates []string
+
+	for _, commonName := range c.ListCertificates() {
+		certificate, err := c.loadCertificateMeta(commonName)
+		if err != nil {
+			log.Printf("goca: skipping %s: %s", commonName, err.Error())
+			continue
+		}
+
+		if certificate == nil {
+			continue
+		}
+
+		if !certificate.NotBefore.Before(start) && certificate.NotBefore.Before(end) {
+			certificates = append(certificates, commonName)
+		}
+	}
+
+	return certificates, nil
+}
+
+func (c *CA) auditKeys() (*KeyAuditReport, error) {
+
+	report := &KeyAuditReport{
+		ReusedKeys: make(map[string][]string),
+	}
+	keys := make(map[string][]string)
+
+	for _, commonName := range c.ListCertificates() {
+		certificate, err := c.loadCertificateMeta(commonName)
+		if err != nil {
+			return nil, err
+		}
+
+		if certificate == nil {
+			continue
+		}
+
+		fingerprint, err := key.PublicKeyFingerprint(certificate.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		keys[fingerprint] = append(keys[fingerprint], commonName)
+
+		if publicKey, ok := certificate.PublicKey.(*rsa.PublicKey); ok {
+			if publicKey.N.BitLen() < MinRSAKeyBitSize {
+				report.WeakKeys = append(report.WeakKeys, commonName)
+			}
+		}
+	}
+
+	for fingerprint, commonNames := range keys {
+		if len(commonNames) > 1 {
+			report.ReusedKeys[fingerprint] = commonNames
+		}
+	}
+
+	return report, nil
+}
+
+func (c *CA) statusManifest() ([]byte, error) {
+
+	if c.Data.signer == nil {
+		return nil, ErrCAMissingPrivateKey
+	}
+
+	manifest := StatusManifest{
+		CommonName:   c.CommonName,
+		GeneratedAt:  time.Now().UTC(),
+		Certificates: []StatusManifestEntry{},
+	}
+
+	for _, commonName := range c.ListCertificates() {
+		certificate, err := c.loadCertificateMeta(commonName)
+		if err != nil {
+			return nil, err
+		}
+
+		if certificate == nil {
+			continue
+		}
+
+		manifest.Certificates = append(manifest.Certificates, StatusManifestEntry{
+			CommonName:   commonName,
+			SerialNumber: certificate.SerialNumber.String(),
+			NotBefore:    certificate.NotBefore,
+			NotAfter:     certificate.NotAfter,
+			Status:       c.certificateStatus(certificate).String(),
+		})
+	}
+
+	manifestBytes, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := signManifest(c.Data.signer, manifestBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(SignedStatusManifest{
+		Manifest:  manifestBytes,
+		Signature: signature,
+	})
+}
+
+// signManifest signs the manifest with the CA private key: RSA PKCS#1 v1.5 or
+// ECDSA over the SHA-256 digest, or pure Ed25519.
+func signManifest(signer crypto.Signer, manifest []byte) ([]byte, error) {
+	if signer == nil {
+		return nil, ErrCAMissingPrivateKey
+	}
+
+	if _, ok := signer.(ed25519.PrivateKey); ok {
+		return signer.Sign(rand.Reader, manifest, crypto.Hash(0))
+	}
+
+	digest := sha256.Sum256(manifest)
+
+	return signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// verifyManifest verifies the manifest signature made by signManifest.
+func verifyManifest(publicKey crypto.PublicKey, manifest, signature []byte) error {
+	switch publicKey := publicKey.(type) {
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(manifest)
+		return rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(publicKey, manifest, signature) {
+			return ErrManifestSignature
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(manifest)
+		if !ecdsa.VerifyASN1(publicKey, digest[:], signature) {
+			return ErrManifestSignature
+		}
+		return nil
+	}
+
+	return ErrManifestSignature
+}
+
+func (c *CA) reissueCertificate(commonName string, valid int) error {
+
+	certificate, err := c.loadCertificate(commonName)
+	if err != nil {
+		return err
+	}
+
+	if certificate.certificate == nil {
+		return ErrCertInvalid
+	}
+
+	if certificate.signer == nil {
+		return ErrCertMissingPrivateKey
+	}
+
+	if c.isRevoked(certificate.certificate) {
+		return ErrCertRevoked
+	}
+
+	// already issued by the current CA Certificate
+	if !certificate.certificate.NotBefore.Before(c.Data.certificate.NotBefore) && certificate.certificate.CheckSignatureFrom(c.Data.certificate) == nil {
+		return nil
+	}
+
+	csrTemplate := x509.CertificateRequest{
+		RawSubject:     certificate.certificate.RawSubject,
+		DNSNames:       certificate.certificate.DNSNames,
+		EmailAddresses: certificate.certificate.EmailAddresses,
+		IPAddresses:    certificate.certificate.IPAddresses,
+		URIs:           certificate.certificate.URIs,
+	}
+
+	csrBytes, err := x509.CreateCertificateRequest(rand.Reader, &csrTemplate, certificate.signer)
+	if err != nil {
+		return err
+	}
+
+	csr, err := x509.ParseCertificateRequest(csrBytes)
+	if err != nil {
+		return err
+	}
+
+	err = storage.SaveFile(storage.File{
+		CA:           c.CommonName,
+		CommonName:   commonName,
+		FileType:     storage.FileTypeCSR,
+		CSRData:      csrBytes,
+		CreationType: storage.CreationTypeCertificate,
+		Location:     c.location,
+	})
+	if err != nil {
+		return err
+	}
+
+	signOptions := certificateSignOptions(certificate.certificate, valid)
+	signOptions.BrowserCompatible = c.BrowserCompatible
+	signOptions.Location = c.location
+	certBytes, err := c.signWithSerial(*csr, signOptions)
+	if err != nil {
+		return err
+	}
+
+	if embedChain, embedRoot := embeddedChain(certificate.Certificate); embedChain {
+		return c.saveEmbeddedChain(&certificate, commonName, certBytes, embedRoot)
+	}
+
+	return nil
+}
+
+func (c *CA) renewCertificate(commonName string, valid int) (certificate Certificate, err error) {
+
+	current, err := c.loadCertificate(commonName)
+	if err != nil {
+		return certificate, err
+	}
+
+	if current.certificate == nil {
+		return certificate, ErrCertLoadNotFound
+	}
+
+	if c.isRevoked(current.certificate) {
+		return certificate, ErrCertRevoked
+	}
+
+	// the stored CSR carries the certificate key, subject and SANs
+	if current.CSR == "" {
+		return certificate, ErrCSRNotFound
+	}
+
+	csr := current.csr
+	csr.Subject.CommonName = commonName
+
+	certificate, err = c.signCSR(csr, certificateSignOptions(current.certificate, valid))
+	if err != nil {
+		return certificate, err
+	}
+
+	if embedChain, embedRoot := embeddedChain(current.Certificate); embedChain {
+		certificate.Certificate = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.certificate.Raw}))
+		if err := c.saveEmbeddedChain(&certificate, commonName, certificate.certificate.Raw, embedRoot); err != nil {
+			return certificate, err
+		}
+	}
+
+	certificate.privateKey = current.privateKey
+	certificate.signer = current.signer
+	certificate.PrivateKey = current.PrivateKey
+	certificate.publicKey = current.publicKey
+	certificate.public = current.public
+	certificate.PublicKey = current.PublicKey
+
+	return certificate, nil
+}
+
+// saveEmbeddedChain stores the CA certificate chain, with the root CA
+// certificate if includeRoot, after the certificate in its .crt file
+// (Identity.EmbedChain).
+func (c *CA) saveEmbeddedChain(certificate *Certificate, commonName string, certBytes []byte, includeRoot bool) error {
+	var chainBytes [][]byte
+	for _, caCertificate := range certificateChain(c.location, c.Data.certificate, includeRoot) {
+		chainBytes = append(chainBytes, caCertificate.Raw)
+		certificate.Certificate += string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCertificate.Raw}))
+	}
+
+	return storage.SaveFile(storage.File{
+		CA:            c.CommonName,
+		CommonName:    commonName,
+		FileType:      storage.FileTypeCertificate,
+		CertData:      certBytes,
+		CertChainData: chainBytes,
+		CreationType:  storage.CreationTypeCertificate,
+		Location:      c.location,
+	})
+}
+
+// embeddedChain returns if the certificate PEM embeds the CA certificate
+// chain after the certificate (Identity.EmbedChain), and if the chain
+// includes the root CA certificate (Identity.EmbedRoot).
+func embeddedChain(certificatePEM string) (embedChain, embedRoot bool) {
+	var last *x509.Certificate
+	rest := []byte(certificatePEM)
+	for blocks := 0; ; blocks++ {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			embedChain = blocks > 1
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			last, _ = x509.ParseCertificate(block.Bytes)
+		}
+	}
+
+	return embedChain, embedChain && last != nil && isSelfSigned(last)
+}
+
+// certificateSignOptions returns the SignOptions signing the certificate
+// again, replacing it, with the same email placement, key usages, policies
+// and CPS URIs, UPNs, OCSP no check, Authority Information Access and CRL
+// Distribution Points.
+func certificateSignOptions(certificate *x509.Certificate, valid int) cert.SignOptions {
+	return cert.SignOptions{
+		Valid:          valid,
+		EmailPlacement: certificateEmailPlacement(certificate),
+		PolicyOIDs:     certificate.PolicyIdentifiers,
+		CPSURIs:        cert.CertificateCPSURIs(certificate),
+		UPNs:           cert.CertificateUPNs(certificate),
+		Overwrite:      true,
+		OCSPNoCheck:    cert.CertificateOCSPNoCheck(certificate),
+		OCSPServers:    certificate.OCSPServer,
+		CAIssuersURLs:  certificate.IssuingCertificateURL,
+		CRLURLs:        certificate.CRLDistributionPoints,
+		KeyUsage:       certificate.KeyUsage,
+		ExtKeyUsage:    certificate.ExtKeyUsage,
+	}
+}
+
+// certificateEmailPlacement returns where the certificate has the email
+// addresses: Subject, Subject Alternative Name or both.
+func certificateEmailPlacement(certificate *x509.Certificate) cert.EmailPlacement {
+	if subjectHasEmailAddress(certificate.Subject) {
+		if len(certificate.EmailAddresses) > 0 {
+			return cert.EmailInBoth
+		}
+		return cert.EmailInSubject
+	}
+
+	return cert.EmailInSAN
+}
+
+func subjectHasEmailAddress(subject pkix.Name) bool {
+	for _, attribute := range subject.Names {
+		if attribute.Type.Equal(asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func issuerCertificate(certificate *x509.Certificate, caCertificates []*x509.Certificate) (*x509.Certificate, error) {
+	if certificate == nil {
+		return nil, ErrCertInvalid
+	}
+
+	for _, caCertificate := range caCertificates {
+		if caCertificate == nil {
+			continue
+		}
+
+		if len(certificate.AuthorityKeyId) > 0 && len(caCertificate.SubjectKeyId) > 0 &&
+			!bytes.Equal(certificate.AuthorityKeyId, caCertificate.SubjectKeyId) {
+			continue
+		}
+
+		if certificate.CheckSignatureFrom(caCertificate) == nil {
+			return caCertificate, nil
+		}
+	}
+
+	return nil, ErrIssuerNotFound
+}
+
+func isSelfSigned(certificate *x509.Certificate) bool {
+	return bytes.Equal(certificate.RawIssuer, certificate.RawSubject) && certificate.CheckSignatureFrom(certificate) == nil
+}
+
+// certificateChain returns the CA certificate chain, as caCertificateChain,
+// without the root CA certificate unless includeRoot.
+func certificateChain(loc storage.Location, caCertificate *x509.Certificate, includeRoot bool) (chain []*x509.Certificate) {
+	for _, chainCert := range caCertificateChain(loc, caCertificate) {
+		if !includeRoot && isSelfSigned(chainCert) {
+			continue
+		}
+		chain = append(chain, chainCert)
+	}
+
+	return chain
+}
+
+// caCertificateChain returns the CA Certificate followed by the parent CA
+// certificates, loaded from the location, up to the root CA certificate.
+func caCertificateChain(loc storage.Location, caCertificate *x509.Certificate) (chain []*x509.Certificate) {
+	chain, _ = loadCACertificateChain(loc, caCertificate)
+
+	return chain
+}
+
+// loadCACertificateChain is caCertificateChain returning ErrIssuerNotFound,
+// with the chain loaded so far, when a parent CA certificate is not found.
+func loadCACertificateChain(loc storage.Location, caCertificate *x509.Certificate) (chain []*x509.Certificate, err error) {
+	for caCertificate != nil {
+		chain = append(chain, caCertificate)
+
+		if isSelfSigned(caCertificate) || len(chain) > maxChainLength {
+			break
+		}
+
+		// only the certificate, the parent CA private key may be encrypted
+		commonName := caCertificate.Issuer.CommonName
+		certString, err := storage.LoadFileIn(loc, commonName, "ca", storage.FileNameIn(loc, storage.FileTypeCertificate, commonName))
+		if err != nil {
+			return chain, ErrIssuerNotFound
+		}
+		parent, err := cert.LoadCert(certString)
+		if err != nil || parent == nil {
+			return chain, ErrIssuerNotFound
+		}
+		caCertificate = parent
+	}
+
+	return chain, nil
+}
+
+// certificateChainBytes returns the certificate followed by the CA
+// certificates up to the root CA certificate, as DER.
+func certificateChainBytes(certificate *Certificate) (chain [][]byte, err error) {
+	if certificate.certificate == nil {
+		return nil, ErrCertInvalid
+	}
+
+	chain = append(chain, certificate.certificate.Raw)
+	if isSelfSigned(certificate.certificate) {
+		return chain, nil
+	}
+	if certificate.caCertificate == nil {
+		return chain, ErrIssuerNotFound
+	}
+
+	caChain, err := loadCACertificateChain(certificate.location, certificate.caCertificate)
+	for _, caCertificate := range caChain {
+		chain = append(chain, caCertificate.Raw)
+	}
+
+	return chain, err
+}
+
+// certificateTLS returns the certificate, the intermediate CA certificates and
+// the private key as tls.Certificate. The root CA certificate is not included,
+// the clients have it.
+func certificateTLS(certificate *Certificate) (tls.Certificate, error) {
+	if certificate.signer == nil {
+		return tls.Certificate{}, ErrCertMissingPrivateKey
+	}
+
+	chain, err := certificateChainBytes(certificate)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if len(chain) > 1 {
+		chain = chain[:len(chain)-1]
+	}
+
+	return tls.Certificate{
+		Certificate: chain,
+		PrivateKey:  certificate.signer,
+		Leaf:        certificate.certificate,
+	}, nil
+}
+
+// certificatePKCS12 returns the certificate, its private key and the CA
+// certificate chain as a PKCS#12 file, with the options friendly name or the
+// certificate common name.
+func certificatePKCS12(certificate *Certificate, password string, opts PKCS12Options) ([]byte, error) {
+	if certificate.signer == nil {
+		return nil, ErrCertMissingPrivateKey
+	}
+
+	chain, err := certificateChainBytes(certificate)
+	if err != nil {
+		return nil, err
+	}
+
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(certificate.signer)
+	if err != nil {
+		return nil, err
+	}
+
+	friendlyName := opts.FriendlyName
+	if friendlyName == "" {
+		friendlyName = certificate.certificate.Subject.CommonName
+	}
+
+	return storage.EncodePKCS12(keyBytes, chain, friendlyName, password)
+}
+
+func (c *CA) freeze() error {
+	return storage.SaveFile(storage.File{
+		CA:           c.CommonName,
+		CommonName:   c.CommonName,
+		FileType:     storage.FileTypeFrozen,
+		FrozenData:   []byte(time.Now().UTC().Format(time.RFC3339) + "\n"),
+		CreationType: storage.CreationTypeCA,
+		Location:     c.location,
+	})
+}
+
+func (c *CA) unfreeze() error {
+	return storage.RemoveIn(c.location, c.CommonName, "ca", storage.FileNameIn(c.location, storage.FileTypeFrozen, c.CommonName))
+}
+
+// caFrozen returns if the CA has the frozen marker file, checked on every
+// issuance so it applies to all the processes sharing the CA directory.
+func caFrozen(loc storage.Location, commonName string) bool {
+	_, err := storage.LoadFileIn(loc, commonName, "ca", storage.FileNameIn(loc, storage.FileTypeFrozen, commonName))
+
+	return err == nil
+}
+
+// signWithSerial signs the CSR with a random serial number not issued before
+// by the CA, or with the next sequential serial number if SequentialSerial.
+// The serial number is persisted only when the signing succeeds, so the
+// issued serial numbers have no gaps.
+func (c *CA) signWithSerial(csr x509.CertificateRequest, opts cert.SignOptions) ([]byte, error) {
+	if c.IsFrozen() {
+		return nil, ErrCAFrozen
+	}
+
+	lock, _ := serialLocks.LoadOrStore(filepath.Join(c.caPath(), c.CommonName), &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	serials, err := c.issuedSerials()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.SequentialSerial {
+		serial, err := c.lastSerial()
+		if err != nil {
+			return nil, err
+		}
+		opts.SerialNumber = serial.Add(serial, big.NewInt(1))
+	} else {
+		opts.SerialNumber, err = uniqueSerial(serials)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	certBytes, err := cert.CASignCSRWithOptions(c.CommonName, csr, c.Data.certificate, c.Data.signer, opts, storage.CreationTypeCertificate)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.SequentialSerial {
+		err = storage.SaveFile(storage.File{
+			CA:           c.CommonName,
+			CommonName:   c.CommonName,
+			FileType:     storage.FileTypeSerial,
+			SerialData:   []byte(strings.ToUpper(opts.SerialNumber.Text(16)) + "\n"),
+			CreationType: storage.CreationTypeCA,
+			Location:     c.location,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.recordSerial(serials, opts.SerialNumber); err != nil {
+		return nil, err
+	}
+
+	return certBytes, nil
+}
+
+// uniqueSerial returns a random serial number not in the issued serials
+func uniqueSerial(serials []string) (*big.Int, error) {
+	issued := make(map[string]bool, len(serials))
+	for _, serial := range serials {
+		issued[serial] = true
+	}
+
+	for {
+		serial, err := cert.NewSerialNumber()
+		if err != nil {
+			return nil, err
+		}
+		if !issued[strings.ToUpper(serial.Text(16))] {
+			return serial, nil
+		}
+	}
+}
+
+// serialIssued returns if the serial number is in the issued serials
+func serialIssued(serials []string, serial *big.Int) bool {
+	hexSerial := strings.ToUpper(serial.Text(16))
+	for _, issued := range serials {
+		if issued == hexSerial {
+			return true
+		}
+	}
+
+	return false
+}
+
+// issuedSerials returns the hexadecimal serial numbers issued by the CA from
+// the serials index. Without index, e.g. a CA created before the index, it is
+// built from the certificates issued by the CA.
+func (c *CA) issuedSerials() ([]string, error) {
+	serialsString, err := storage.LoadFileIn(c.location, c.CommonName, "ca", storage.FileNameIn(c.location, storage.FileTypeSerials, c.CommonName))
+	if err == nil {
+		return strings.Fields(string(serialsString)), nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	var serials []string
+	if c.Data.certificate != nil {
+		serials = append(serials, strings.ToUpper(c.Data.certificate.SerialNumber.Text(16)))
+	}
+	for _, commonName := range c.ListCertificates() {
+		certificate, err := c.loadCertificateMeta(commonName)
+		if err != nil || certificate == nil {
+			continue
+		}
+		serials = append(serials, strings.ToUpper(certificate.SerialNumber.Text(16)))
+	}
+
+	return serials, nil
+}
+
+// recordSerial saves the serials index with the new serial number
+func (c *CA) recordSerial(serials []string, serial *big.Int) error {
+	serials = append(serials, strings.ToUpper(serial.Text(16)))
+
+	return storage.SaveFile(storage.File{
+		CA:           c.CommonName,
+		CommonName:   c.CommonName,
+		FileType:     storage.FileTypeSerials,
+		SerialsData:  []byte(strings.Join(serials, "\n") + "\n"),
+		CreationType: storage.CreationTypeCA,
+		Location:     c.location,
+	})
+}
+
+// lastSerial returns the last sequential serial number issued by the CA, from
+// the hexadecimal serial file (as OpenSSL), or zero if none was issued.
+func (c *CA) lastSerial() (*big.Int, error) {
+	serialString, err := storage.LoadFileIn(c.location, c.CommonName, "ca", storage.FileNameIn(c.location, storage.FileTypeSerial, c.CommonName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return big.NewInt(0), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	serial, ok := new(big.Int).SetString(strings.TrimSpace(string(serialString)), 16)
+	if !ok || serial.Sign() < 0 {
+		return nil, ErrSerialFileInvalid
+	}
+
+	return serial, nil
+}
+
+// diffCertificates returns the fields that differ between the certificates
+func diffCertificates(a, b *x509.Certificate) (diffs []FieldDiff, err error) {
+	if a == nil || b == nil {
+		return nil, ErrCertInvalid
+	}
+
+	publicKeyA, err := key.PublicKeyFingerprint(a.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	publicKeyB, err := key.PublicKeyFingerprint(b.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := []FieldDiff{
+		{FieldSubject, a.Subject.String(), b.Subject.String()},
+		{FieldSANs, subjectAltNames(a), subjectAltNames(b)},
+		{FieldKeyUsage, strconv.Itoa(int(a.KeyUsage)), strconv.Itoa(int(b.KeyUsage))},
+		{FieldExtKeyUsage, extKeyUsages(a), extKeyUsages(b)},
+		{FieldPublicKey, publicKeyA, publicKeyB},
+		{FieldNotBefore, a.NotBefore.UTC().Format(time.RFC3339), b.NotBefore.UTC().Format(time.RFC3339)},
+		{FieldNotAfter, a.NotAfter.UTC().Format(time.RFC3339), b.NotAfter.UTC().Format(time.RFC3339)},
+		{FieldSerialNumber, a.SerialNumber.String(), b.SerialNumber.String()},
+	}
+	for _, field := range fields {
+		if field.A != field.B {
+			diffs = append(diffs, field)
+		}
+	}
+
+	return diffs, nil
+}
+
+// subjectAltNames returns the certificate Subject Alternative Names sorted, so
+// the order does not matter comparing certificates
+func subjectAltNames(certificate *x509.Certificate) string {
+	var names []string
+	for _, dnsName := range certificate.DNSNames {
+		names = append(names, "DNS:"+dnsName)
+	}
+	for _, emailAddress := range certificate.EmailAddresses {
+		names = append(names, "email:"+emailAddress)
+	}
+	for _, ipAddress := range certificate.IPAddresses {
+		names = append(names, "IP:"+ipAddress.String())
+	}
+	for _, uri := range certificate.URIs {
+		names = append(names, "URI:"+uri.String())
+	}
+	sort.Strings(names)
+
+	return strings.Join(names, ", ")
+}
+
+// extKeyUsages returns the certificate extended key usages sorted
+func extKeyUsages(certificate *x509.Certificate) string {
+	var usages []string
+	for _, extKeyUsage := range certificate.ExtKeyUsage {
+		usages = append(usages, strconv.Itoa(int(extKeyUsage)))
+	}
+	for _, oid := range certificate.UnknownExtKeyUsage {
+		usages = append(usages, oid.String())
+	}
+	sort.Strings(usages)
+
+	return strings.Join(usages, ", ")
+}
@@ -20,7 +20,7 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
 // SOFTWARE.
 
-// Package cert provides RSA Key API management for crypto/x509 certificates.
+// Package cert provides RSA and Ed25519 Key API management for crypto/x509 certificates.
 //
 // This package makes easy to generate and certificates from files to be used
 // by GoLang applications.
@@ -31,15 +31,18 @@
 package cert
 
 import (
+	"crypto"
 	"crypto/rand"
-	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
 	"encoding/pem"
 	"errors"
 	"math/big"
+	"net"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	storage "github.com/kairoaraujo/goca/_storage"
@@ -53,8 +56,20 @@ const (
 	MaxValidCert int = 825
 	// DefaultValidCert is the default valid time: 397 days
 	DefaultValidCert int = 397
-	// Certificate file extension
-	certExtension string = ".crt"
+	// MaxValidCA is the default maximum valid time of the CA certificates:
+	// 7300 days
+	MaxValidCA int = 7300
+)
+
+// MaxBrowserValidity is the maximum validity of TLS server certificates
+// accepted by Apple and the major browsers: 398 days
+const MaxBrowserValidity time.Duration = 398 * 24 * time.Hour
+
+const (
+	// DefaultCRLValidity is the default time until the CRL NextUpdate: 1 day
+	DefaultCRLValidity time.Duration = 24 * time.Hour
+	// MaxCRLValidity is the maximum time until the CRL NextUpdate: 365 days
+	MaxCRLValidity time.Duration = 365 * 24 * time.Hour
 )
 
 // ErrCertExists means that the certificate requested already exists
@@ -62,21 +77,509 @@ var ErrCertExists = errors.New("certificate already exists")
 
 var ErrParentCANotFound = errors.New("parent CA not found")
 
-func newSerialNumber() (serialNumber *big.Int) {
+// ErrInvalidPEMCertificate means that the certificate is not PEM encoded
+var ErrInvalidPEMCertificate = errors.New("invalid PEM encoded certificate")
+
+// ErrInvalidPEMCSR means that the Certificate Signing Request is not PEM
+// encoded
+var ErrInvalidPEMCSR = errors.New("invalid PEM encoded CSR")
+
+// ErrInvalidPEMCRL means that the Certificate Revocation List is not PEM
+// encoded
+var ErrInvalidPEMCRL = errors.New("invalid PEM encoded CRL")
+
+// ErrCAOutsideParentValidity means that the intermediate CA certificate would
+// be valid after the parent CA certificate expires
+var ErrCAOutsideParentValidity = errors.New("the intermediate CA certificate validity is outside of the parent CA validity")
+
+// ErrBrowserValidity means that the TLS server certificate validity is longer
+// than MaxBrowserValidity, rejected by Apple and the major browsers
+var ErrBrowserValidity = errors.New("the TLS server certificate validity must be at most 398 days to be accepted by browsers")
+
+// ErrInvalidCRLValidity means the CRL validity is not between 0 and
+// MaxCRLValidity
+var ErrInvalidCRLValidity = errors.New("the CRL validity must be positive and at most 365 days")
+
+// ErrInvalidValidity means that the certificate valid days are not between
+// MinValidCert and MaxValidCert (CAOptions.MaxValid for CA certificates)
+var ErrInvalidValidity = errors.New("the certificate valid (min/max) is not between 1 - 825")
+
+// ErrInvalidValidityDates means that the certificate NotBefore is not before
+// the NotAfter
+var ErrInvalidValidityDates = errors.New("the certificate NotBefore must be before NotAfter")
+
+var (
+	oidEmailAddress         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}
+	oidCertificatePolicies  = asn1.ObjectIdentifier{2, 5, 29, 32}
+	oidPolicyQualifierIDCPS = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 2, 1}
+	oidSubjectAltName       = asn1.ObjectIdentifier{2, 5, 29, 17}
+	oidUserPrincipalName    = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2, 3}
+	oidOCSPNoCheck          = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5}
+)
+
+// GeneralName tags (RFC 5280 section 4.2.1.6)
+const (
+	nameTypeOtherName = 0
+	nameTypeEmail     = 1
+	nameTypeDNS       = 2
+	nameTypeURI       = 6
+	nameTypeIP        = 7
+)
+
+// otherName is the RFC 5280 OtherName without the implicit [0] tag
+type otherName struct {
+	TypeID asn1.ObjectIdentifier
+	Value  asn1.RawValue
+}
+
+// policyQualifierInfo is the RFC 5280 PolicyQualifierInfo
+type policyQualifierInfo struct {
+	PolicyQualifierID asn1.ObjectIdentifier
+	Qualifier         string `asn1:"ia5"`
+}
+
+// policyInformation is the RFC 5280 PolicyInformation
+type policyInformation struct {
+	PolicyIdentifier asn1.ObjectIdentifier
+	PolicyQualifiers []policyQualifierInfo `asn1:"optional,omitempty"`
+}
+
+// EmailPlacement defines where the email address is placed in the Certificate
+// Signing Request and in the issued Certificate.
+type EmailPlacement int
+
+const (
+	// EmailInSAN places the email address in the Subject Alternative Name (default)
+	EmailInSAN EmailPlacement = iota
+	// EmailInSubject places the email address only in the Subject (compatibility mode)
+	EmailInSubject
+	// EmailInBoth places the email address in the Subject and in the Subject Alternative Name
+	EmailInBoth
+)
+
+func (p EmailPlacement) inSubject() bool {
+	return p == EmailInSubject || p == EmailInBoth
+}
+
+func (p EmailPlacement) inSAN() bool {
+	return p == EmailInSAN || p == EmailInBoth
+}
+
+// SignOptions represents the options used by CASignCSRWithOptions to issue a
+// certificate.
+type SignOptions struct {
+	Valid          int                     // Number of days the certificate is valid
+	EmailPlacement EmailPlacement          // Where the email addresses are placed (default: SAN)
+	PolicyOIDs     []asn1.ObjectIdentifier // Certificate Policies identifiers
+	CPSURIs        []string                // Certification Practice Statement URIs added to each policy
+	NotBefore      time.Time               // Explicit validity start, used with NotAfter instead of Valid
+	NotAfter       time.Time               // Explicit validity end, used with NotBefore instead of Valid
+	UPNs           []string                // User Principal Names added as SAN otherName (Windows smart card logon)
+	Overwrite      bool                    // Replace an existing certificate with the same Common Name (re-issuance)
+	OCSPNoCheck    bool                    // Add the id-pkix-ocsp-nocheck extension (OCSP responder certificates)
+	KeepDNSNames   bool                    // Keep the DNS names as requested instead of lowercased and trimmed
+	ValidityJitter time.Duration           // Random offset within ±ValidityJitter added to NotAfter, used only with Valid (default: none)
+	OCSPServers    []string                // OCSP responder URLs (Authority Information Access extension)
+	CAIssuersURLs  []string                // URLs to fetch the issuing CA certificate (Authority Information Access extension)
+	CRLURLs        []string                // URLs to fetch the CA CRL (CRL Distribution Points extension)
+	KeyUsage       x509.KeyUsage           // Key usage (default: digital signature)
+	ExtKeyUsage    []x509.ExtKeyUsage      // Extended key usages (default: client authentication)
+
+	// Issuer overrides the certificate Issuer DN (default: the CA subject).
+	// RawIssuer, the DER encoded Issuer DN, has precedence over Issuer.
+	//
+	// An Issuer different from the CA Certificate subject breaks the chain
+	// verification. Use it only for controlled migrations.
+	Issuer    *pkix.Name
+	RawIssuer []byte
+
+	// BrowserCompatible limits the TLS server certificates validity to
+	// MaxBrowserValidity, the maximum accepted by Apple and the browsers.
+	// Longer requested validities fail with ErrBrowserValidity. Certificates
+	// without the server authentication usage are not limited.
+	BrowserCompatible bool
+
+	// Location is the base path and storage where the certificate is stored
+	// (default: $CAPATH in the file system)
+	Location storage.Location
+
+	// SerialNumber is the certificate serial number (default: random)
+	SerialNumber *big.Int
+
+	// Template overrides the certificate defaults with its non-zero key
+	// usages, basic constraints, URIs, Authority Information Access, CRL
+	// Distribution Points, policies and subject key identifier. Its extra
+	// extensions are added. The subject, SANs, validity, serial number,
+	// issuer and public key are not taken from the Template.
+	Template *x509.Certificate
+}
+
+// CSROptions represents the options used by CreateCSRWithOptions to create a
+// Certificate Signing Request.
+type CSROptions struct {
+	SubjectSerialNumber string           // Subject DN serialNumber attribute (e.g. device identifier), not the certificate serial number
+	Location            storage.Location // Base path and storage where the CSR is stored (default: $CAPATH in the file system)
+
+	// IP addresses added to the Subject Alternative Name
+	IPAddresses []net.IP
+}
+
+// CRLOptions represents the options used by RevokeCertificateWithOptions to
+// create a CRL.
+type CRLOptions struct {
+	Number   *big.Int         // CRL number, must increase with every CRL issued by the CA (default: random)
+	Validity time.Duration    // Time from ThisUpdate to NextUpdate (default: DefaultCRLValidity)
+	Location storage.Location // Base path and storage where the CRL is stored (default: $CAPATH in the file system)
+}
+
+// CAOptions represents the options used by CreateCACertWithOptions to create a
+// CA certificate.
+type CAOptions struct {
+	PermittedDNSDomains []string         // Name Constraints: DNS domains (and subdomains) the CA can issue for
+	ExcludedDNSDomains  []string         // Name Constraints: DNS domains (and subdomains) the CA cannot issue for
+	Location            storage.Location // Base path and storage where the certificate is stored (default: $CAPATH in the file system)
+	StrictValidity      bool             // Fail with ErrCAOutsideParentValidity instead of limiting the intermediate CA validity to the parent CA validity
+	SerialNumber        *big.Int         // Certificate serial number (default: random)
+	MaxValid            int              // Maximum valid days of the CA certificate (default: MaxValidCA)
+	MaxPathLen          *int             // Maximum number of intermediate CAs below the CA (default: unconstrained)
+}
+
+// applyTemplate overrides the certificate defaults with the non-zero fields of
+// the template, as described in SignOptions.Template
+func applyTemplate(certificate, template *x509.Certificate) {
+	if template.KeyUsage != 0 {
+		certificate.KeyUsage = template.KeyUsage
+	}
+	if len(template.ExtKeyUsage) > 0 || len(template.UnknownExtKeyUsage) > 0 {
+		certificate.ExtKeyUsage = template.ExtKeyUsage
+		certificate.UnknownExtKeyUsage = template.UnknownExtKeyUsage
+	}
+	if template.BasicConstraintsValid {
+		certificate.IsCA = template.IsCA
+		certificate.MaxPathLen = template.MaxPathLen
+		certificate.MaxPathLenZero = template.MaxPathLenZero
+	}
+	if len(template.URIs) > 0 {
+		certificate.URIs = template.URIs
+	}
+	if len(template.OCSPServer) > 0 {
+		certificate.OCSPServer = template.OCSPServer
+	}
+	if len(template.IssuingCertificateURL) > 0 {
+		certificate.IssuingCertificateURL = template.IssuingCertificateURL
+	}
+	if len(template.CRLDistributionPoints) > 0 {
+		certificate.CRLDistributionPoints = template.CRLDistributionPoints
+	}
+	if len(template.PolicyIdentifiers) > 0 {
+		certificate.PolicyIdentifiers = template.PolicyIdentifiers
+	}
+	if len(template.SubjectKeyId) > 0 {
+		certificate.SubjectKeyId = template.SubjectKeyId
+	}
+	certificate.ExtraExtensions = append(certificate.ExtraExtensions, template.ExtraExtensions...)
+}
+
+// isServerAuth returns true if the extended key usages allow TLS server
+// authentication (no extended key usage allows any usage)
+func isServerAuth(extKeyUsages []x509.ExtKeyUsage) bool {
+	if len(extKeyUsages) == 0 {
+		return true
+	}
+
+	for _, extKeyUsage := range extKeyUsages {
+		if extKeyUsage == x509.ExtKeyUsageServerAuth || extKeyUsage == x509.ExtKeyUsageAny {
+			return true
+		}
+	}
+
+	return false
+}
+
+// jitter returns notAfter moved by a random offset within ±maxJitter, spreading
+// the expiration of certificates issued at the same time. The result is kept
+// after notBefore and clamped to maxNotAfter.
+func jitter(notBefore, notAfter, maxNotAfter time.Time, maxJitter time.Duration) (time.Time, error) {
+	offset, err := rand.Int(key.Reader(), big.NewInt(2*int64(maxJitter)+1))
+	if err != nil {
+		return notAfter, err
+	}
+
+	jittered := notAfter.Add(time.Duration(offset.Int64()) - maxJitter)
+	if !jittered.After(notBefore) {
+		return notAfter, nil
+	}
+	if jittered.After(maxNotAfter) {
+		return maxNotAfter, nil
+	}
+
+	return jittered, nil
+}
+
+// normalizeDNSNames returns the DNS names lowercased and without surrounding
+// whitespace. DNS names are case-insensitive, but mixed-case SANs break the
+// comparisons of some clients (e.g. certificate pinning).
+func normalizeDNSNames(dnsNames []string) []string {
+	if dnsNames == nil {
+		return nil
+	}
+
+	normalized := make([]string, 0, len(dnsNames))
+	for _, dnsName := range dnsNames {
+		normalized = append(normalized, strings.ToLower(strings.TrimSpace(dnsName)))
+	}
+
+	return normalized
+}
+
+// subjectAltNameExtension returns the subjectAltName extension with the
+// certificate DNS names, email addresses, IP addresses and URIs plus the User
+// Principal Names as otherName entries.
+//
+// Go does not encode otherName, so the whole extension is built here and
+// replaces the one generated by x509.CreateCertificate.
+func subjectAltNameExtension(template *x509.Certificate, upns []string) (extension pkix.Extension, err error) {
+	var rawValues []asn1.RawValue
+
+	for _, upn := range upns {
+		upnValue, err := asn1.MarshalWithParams(upn, "utf8")
+		if err != nil {
+			return extension, err
+		}
+
+		otherNameBytes, err := asn1.Marshal(otherName{
+			TypeID: oidUserPrincipalName,
+			Value:  asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: upnValue},
+		})
+		if err != nil {
+			return extension, err
+		}
+
+		var otherNameSequence asn1.RawValue
+		if _, err := asn1.Unmarshal(otherNameBytes, &otherNameSequence); err != nil {
+			return extension, err
+		}
+
+		rawValues = append(rawValues, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: nameTypeOtherName, IsCompound: true, Bytes: otherNameSequence.Bytes})
+	}
+	for _, email := range template.EmailAddresses {
+		rawValues = append(rawValues, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: nameTypeEmail, Bytes: []byte(email)})
+	}
+	for _, dnsName := range template.DNSNames {
+		rawValues = append(rawValues, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: nameTypeDNS, Bytes: []byte(dnsName)})
+	}
+	for _, uri := range template.URIs {
+		rawValues = append(rawValues, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: nameTypeURI, Bytes: []byte(uri.String())})
+	}
+	for _, ip := range template.IPAddresses {
+		if ipv4 := ip.To4(); ipv4 != nil {
+			ip = ipv4
+		}
+		rawValues = append(rawValues, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: nameTypeIP, Bytes: ip})
+	}
+
+	extension.Id = oidSubjectAltName
+	extension.Value, err = asn1.Marshal(rawValues)
+
+	return extension, err
+}
+
+// certificatePoliciesExtension returns the certificatePolicies extension with
+// the CPS URIs as policy qualifiers of each policy.
+func certificatePoliciesExtension(policyOIDs []asn1.ObjectIdentifier, cpsURIs []string) (extension pkix.Extension, err error) {
+	var qualifiers []policyQualifierInfo
+	for _, cpsURI := range cpsURIs {
+		qualifiers = append(qualifiers, policyQualifierInfo{
+			PolicyQualifierID: oidPolicyQualifierIDCPS,
+			Qualifier:         cpsURI,
+		})
+	}
+
+	var policies []policyInformation
+	for _, policyOID := range policyOIDs {
+		policies = append(policies, policyInformation{
+			PolicyIdentifier: policyOID,
+			PolicyQualifiers: qualifiers,
+		})
+	}
+
+	extension.Id = oidCertificatePolicies
+	extension.Value, err = asn1.Marshal(policies)
+
+	return extension, err
+}
+
+// CertificateCPSURIs returns the Certification Practice Statement URIs of the
+// certificate policies, as added with SignOptions.CPSURIs.
+func CertificateCPSURIs(certificate *x509.Certificate) (cpsURIs []string) {
+	seen := make(map[string]bool)
+	for _, extension := range certificate.Extensions {
+		if !extension.Id.Equal(oidCertificatePolicies) {
+			continue
+		}
+		var policies []policyInformation
+		if _, err := asn1.Unmarshal(extension.Value, &policies); err != nil {
+			return nil
+		}
+		for _, policy := range policies {
+			for _, qualifier := range policy.PolicyQualifiers {
+				if qualifier.PolicyQualifierID.Equal(oidPolicyQualifierIDCPS) && !seen[qualifier.Qualifier] {
+					seen[qualifier.Qualifier] = true
+					cpsURIs = append(cpsURIs, qualifier.Qualifier)
+				}
+			}
+		}
+	}
+
+	return cpsURIs
+}
+
+// CertificateUPNs returns the User Principal Names of the certificate Subject
+// Alternative Name, as added with SignOptions.UPNs.
+func CertificateUPNs(certificate *x509.Certificate) (upns []string) {
+	for _, extension := range certificate.Extensions {
+		if !extension.Id.Equal(oidSubjectAltName) {
+			continue
+		}
+		var rawValues []asn1.RawValue
+		if _, err := asn1.Unmarshal(extension.Value, &rawValues); err != nil {
+			return nil
+		}
+		for _, rawValue := range rawValues {
+			if rawValue.Class != asn1.ClassContextSpecific || rawValue.Tag != nameTypeOtherName {
+				continue
+			}
+			otherNameBytes, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSequence, IsCompound: true, Bytes: rawValue.Bytes})
+			if err != nil {
+				continue
+			}
+			var name otherName
+			if _, err := asn1.Unmarshal(otherNameBytes, &name); err != nil || !name.TypeID.Equal(oidUserPrincipalName) {
+				continue
+			}
+			var upn string
+			if _, err := asn1.Unmarshal(name.Value.Bytes, &upn); err == nil {
+				upns = append(upns, upn)
+			}
+		}
+	}
+
+	return upns
+}
+
+// CertificateOCSPNoCheck returns if the certificate has the OCSP no check
+// extension, as added with SignOptions.OCSPNoCheck.
+func CertificateOCSPNoCheck(certificate *x509.Certificate) bool {
+	for _, extension := range certificate.Extensions {
+		if extension.Id.Equal(oidOCSPNoCheck) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rawSubject returns the ASN.1 subject including the email addresses as
+// emailAddress attributes.
+func rawSubject(subject pkix.Name, emailAddresses []string) ([]byte, error) {
+	rdn := subject.ToRDNSequence()
+	for _, email := range emailAddresses {
+		rdn = append(rdn, []pkix.AttributeTypeAndValue{
+			{Type: oidEmailAddress, Value: email},
+		})
+	}
+
+	return asn1.Marshal(rdn)
+}
+
+// csrEmailAddresses returns the email addresses found in the CSR subject and
+// in the CSR Subject Alternative Name, without duplicates.
+func csrEmailAddresses(csr x509.CertificateRequest) (emailAddresses []string) {
+	seen := make(map[string]bool)
+	add := func(email string) {
+		if email != "" && !seen[email] {
+			seen[email] = true
+			emailAddresses = append(emailAddresses, email)
+		}
+	}
+
+	for _, attribute := range csr.Subject.Names {
+		if attribute.Type.Equal(oidEmailAddress) {
+			if email, ok := attribute.Value.(string); ok {
+				add(email)
+			}
+		}
+	}
+	for _, email := range csr.EmailAddresses {
+		add(email)
+	}
+
+	return emailAddresses
+}
+
+var (
+	serialMu      sync.Mutex
+	serialCounter *big.Int // sequential serial numbers, only in the test mode
+)
+
+// SetTestMode enables (or disables) the test mode, where the serial numbers
+// are sequential starting at 1 and the fixed random source from key is used
+// to sign. It makes the certificates reproducible for tests.
+//
+// Never enable it in production.
+func SetTestMode(enabled bool) {
+	serialMu.Lock()
+	defer serialMu.Unlock()
+
+	serialCounter = nil
+	if enabled {
+		serialCounter = big.NewInt(0)
+	}
+	key.SetTestMode(enabled)
+}
+
+// NewSerialNumber returns a random serial number compliant with RFC 5280
+// section 4.1.2.2: a positive integer up to 20 octets (here 128 bits).
+//
+// In the test mode the serial numbers are sequential.
+func NewSerialNumber() (serialNumber *big.Int, err error) {
+	serialMu.Lock()
+	if serialCounter != nil {
+		serialCounter.Add(serialCounter, big.NewInt(1))
+		serialNumber = new(big.Int).Set(serialCounter)
+	}
+	serialMu.Unlock()
+	if serialNumber != nil {
+		return serialNumber, nil
+	}
+
 	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
-	serialNumber, _ = rand.Int(rand.Reader, serialNumberLimit)
 
-	return serialNumber
+	for serialNumber == nil || serialNumber.Sign() == 0 {
+		serialNumber, err = rand.Int(rand.Reader, serialNumberLimit)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return serialNumber, nil
 }
 
 // CreateCSR creates a Certificate Signing Request returning certData with CSR.
+// The email address is placed in the Subject Alternative Name.
 //
 // The CSR is also stored in $CAPATH with extension .csr
-func CreateCSR(CACommonName, commonName, country, province, locality, organization, organizationalUnit, emailAddresses string, dnsNames []string, priv *rsa.PrivateKey, creationType storage.CreationType) (csr []byte, err error) {
-	var oidEmailAddress = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}
+func CreateCSR(CACommonName, commonName, country, province, locality, organization, organizationalUnit, emailAddresses string, dnsNames []string, priv crypto.Signer, creationType storage.CreationType) (csr []byte, err error) {
+	return CreateCSRWithOptions(CACommonName, commonName, country, province, locality, organization, organizationalUnit, emailAddresses, dnsNames, EmailInSAN, priv, CSROptions{}, creationType)
+}
 
+// CreateCSRWithOptions is CreateCSR with CSROptions. The emailPlacement
+// defines if the email address goes to the Subject, to the Subject Alternative
+// Name or both.
+func CreateCSRWithOptions(CACommonName, commonName, country, province, locality, organization, organizationalUnit, emailAddresses string, dnsNames []string, emailPlacement EmailPlacement, priv crypto.Signer, opts CSROptions, creationType storage.CreationType) (csr []byte, err error) {
 	subject := pkix.Name{
 		CommonName:         commonName,
+		SerialNumber:       opts.SubjectSerialNumber,
 		Country:            []string{country},
 		Province:           []string{province},
 		Locality:           []string{locality},
@@ -84,21 +587,28 @@ func CreateCSR(CACommonName, commonName, country, province, locality, organizati
 		OrganizationalUnit: []string{organizationalUnit},
 	}
 
-	rawSubj := subject.ToRDNSequence()
-	rawSubj = append(rawSubj, []pkix.AttributeTypeAndValue{
-		{Type: oidEmailAddress, Value: emailAddresses},
-	})
-	asn1Subj, _ := asn1.Marshal(rawSubj)
+	// The signature algorithm is chosen by the key algorithm
 	template := x509.CertificateRequest{
-		RawSubject:         asn1Subj,
-		EmailAddresses:     []string{emailAddresses},
-		SignatureAlgorithm: x509.SHA256WithRSA,
+		Subject: subject,
+	}
+
+	if emailAddresses != "" {
+		if emailPlacement.inSubject() {
+			template.RawSubject, err = rawSubject(subject, []string{emailAddresses})
+			if err != nil {
+				return csr, err
+			}
+		}
+		if emailPlacement.inSAN() {
+			template.EmailAddresses = []string{emailAddresses}
+		}
 	}
 
 	dnsNames = append(dnsNames, commonName)
 	template.DNSNames = dnsNames
+	template.IPAddresses = opts.IPAddresses
 
-	csr, err = x509.CreateCertificateRequest(rand.Reader, &template, priv)
+	csr, err = x509.CreateCertificateRequest(key.Reader(), &template, priv)
 	if err != nil {
 		return csr, err
 	}
@@ -109,6 +619,7 @@ func CreateCSR(CACommonName, commonName, country, province, locality, organizati
 		FileType:     storage.FileTypeCSR,
 		CSRData:      csr,
 		CreationType: creationType,
+		Location:     opts.Location,
 	}
 
 	err = storage.SaveFile(fileData)
@@ -122,38 +633,73 @@ func CreateCSR(CACommonName, commonName, country, province, locality, organizati
 
 // LoadCSR loads a Certificate Signing Request from a read file.
 //
-// Using ioutil.ReadFile() satisfyies the read file.
+// Using ioutil.ReadFile() satisfyies the read file. It returns ErrInvalidPEMCSR
+// if the file is not PEM encoded.
 func LoadCSR(csrString []byte) (*x509.CertificateRequest, error) {
 	block, _ := pem.Decode([]byte(string(csrString)))
-	csr, _ := x509.ParseCertificateRequest(block.Bytes)
+	if block == nil {
+		return nil, ErrInvalidPEMCSR
+	}
 
-	return csr, nil
+	return x509.ParseCertificateRequest(block.Bytes)
 }
 
 // LoadCRL loads a Certificate Revocation List from a read file.
 //
-// Using ioutil.ReadFile() satisfyies the read file.
+// Using ioutil.ReadFile() satisfyies the read file. It returns ErrInvalidPEMCRL
+// if the file is not PEM encoded.
 func LoadCRL(crlString []byte) (*pkix.CertificateList, error) {
 	block, _ := pem.Decode([]byte(string(crlString)))
-	crl, _ := x509.ParseCRL(block.Bytes)
+	if block == nil {
+		return nil, ErrInvalidPEMCRL
+	}
 
-	return crl, nil
+	return x509.ParseCRL(block.Bytes)
 }
 
 // LoadParentCACertificate loads parent CA's certificate and private key
 //
 // TODO maybe make this more generic, something like LoadCACertificate that
 // returns the certificate and private/public key
-func LoadParentCACertificate(commonName string) (certificate *x509.Certificate, privateKey *rsa.PrivateKey, err error) {
-	caStorage := storage.CAStorage(commonName)
+func LoadParentCACertificate(commonName string) (certificate *x509.Certificate, privateKey crypto.Signer, err error) {
+	return LoadParentCACertificateIn("", commonName)
+}
+
+// LoadParentCACertificateIn is LoadParentCACertificate for an explicit base
+// path (empty is $CAPATH)
+func LoadParentCACertificateIn(basePath, commonName string) (certificate *x509.Certificate, privateKey crypto.Signer, err error) {
+	return LoadParentCACertificateWithPassphrase(basePath, commonName, nil)
+}
+
+// LoadParentCACertificateWithPassphrase is LoadParentCACertificateIn for a
+// parent CA private key encrypted with the passphrase
+func LoadParentCACertificateWithPassphrase(basePath, commonName string, passphrase []byte) (certificate *x509.Certificate, privateKey crypto.Signer, err error) {
+	return LoadParentCACertificateWithOptions(commonName, ParentCAOptions{Location: storage.Location{Path: basePath}, Passphrase: passphrase})
+}
+
+// ParentCAOptions represents the options used by
+// LoadParentCACertificateWithOptions to load the parent CA.
+type ParentCAOptions struct {
+	Location   storage.Location     // Base path and storage of the CAs (default: $CAPATH in the file system)
+	Passphrase []byte               // Decrypts the parent CA private key (default: not encrypted)
+	KeyDecoder storage.KeyCodecFunc // Unwraps the stored private key PEM (default: nil, the plain PEM)
+}
+
+// LoadParentCACertificateWithOptions is LoadParentCACertificate using the
+// ParentCAOptions
+func LoadParentCACertificateWithOptions(commonName string, opts ParentCAOptions) (certificate *x509.Certificate, privateKey crypto.Signer, err error) {
+	loc := opts.Location
+	passphrase := opts.Passphrase
+
+	caStorage := storage.CAStorageIn(loc, commonName)
 	if !caStorage {
 		return nil, nil, ErrParentCANotFound
 	}
 
 	var caDir = filepath.Join(commonName, "ca")
 
-	if keyString, loadErr := storage.LoadFile(filepath.Join(caDir, "key.pem")); loadErr == nil {
-		privateKey, err = key.LoadPrivateKey(keyString)
+	if keyString, loadErr := storage.LoadKeyFileIn(loc, opts.KeyDecoder, filepath.Join(caDir, storage.FileNameIn(loc, storage.FileTypeKey, commonName))); loadErr == nil {
+		privateKey, err = key.LoadSignerWithPassphrase(keyString, passphrase)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -161,7 +707,7 @@ func LoadParentCACertificate(commonName string) (certificate *x509.Certificate,
 		return nil, nil, loadErr
 	}
 
-	if certString, loadErr := storage.LoadFile(filepath.Join(caDir, commonName+certExtension)); loadErr == nil {
+	if certString, loadErr := storage.LoadFileIn(loc, filepath.Join(caDir, storage.FileNameIn(loc, storage.FileTypeCertificate, commonName))); loadErr == nil {
 		certificate, err = LoadCert(certString)
 		if err != nil {
 			return nil, nil, err
@@ -184,8 +730,8 @@ func CreateRootCert(
 	emailAddresses string,
 	valid int,
 	dnsNames []string,
-	privateKey *rsa.PrivateKey,
-	publicKey *rsa.PublicKey,
+	privateKey crypto.Signer,
+	publicKey crypto.PublicKey,
 	creationType storage.CreationType,
 ) (cert []byte, err error) {
 	cert, err = CreateCACert(
@@ -224,16 +770,69 @@ func CreateCACert(
 	validDays int,
 	dnsNames []string,
 	privateKey,
-	parentPrivateKey *rsa.PrivateKey,
+	parentPrivateKey crypto.Signer,
+	parentCertificate *x509.Certificate,
+	publicKey crypto.PublicKey,
+	creationType storage.CreationType,
+) (cert []byte, err error) {
+	return CreateCACertWithOptions(
+		CACommonName,
+		commonName,
+		country,
+		province,
+		locality,
+		organization,
+		organizationalUnit,
+		emailAddresses,
+		validDays,
+		dnsNames,
+		privateKey,
+		parentPrivateKey,
+		parentCertificate,
+		publicKey,
+		CAOptions{},
+		creationType)
+}
+
+// CreateCACertWithOptions creates a CA Certificate using the CAOptions
+//
+// See CreateCACert for the root and intermediate CA parameters.
+func CreateCACertWithOptions(
+	CACommonName,
+	commonName,
+	country,
+	province,
+	locality,
+	organization,
+	organizationalUnit,
+	emailAddresses string,
+	validDays int,
+	dnsNames []string,
+	privateKey,
+	parentPrivateKey crypto.Signer,
 	parentCertificate *x509.Certificate,
-	publicKey *rsa.PublicKey,
+	publicKey crypto.PublicKey,
+	opts CAOptions,
 	creationType storage.CreationType,
 ) (cert []byte, err error) {
+	maxValid := opts.MaxValid
+	if maxValid == 0 {
+		maxValid = MaxValidCA
+	}
 	if validDays == 0 {
 		validDays = DefaultValidCert
+	} else if validDays < MinValidCert || validDays > maxValid {
+		return nil, ErrInvalidValidity
+	}
+	serialNumber := opts.SerialNumber
+	if serialNumber == nil {
+		serialNumber, err = NewSerialNumber()
+		if err != nil {
+			return nil, err
+		}
 	}
 	caCert := &x509.Certificate{
-		SerialNumber: newSerialNumber(),
+		SerialNumber: serialNumber,
 		Subject: pkix.Name{
 			CommonName:         commonName,
 			Organization:       []string{organization},
@@ -254,6 +853,26 @@ func CreateCACert(
 	dnsNames = append(dnsNames, commonName)
 	caCert.DNSNames = dnsNames
 
+	if len(opts.PermittedDNSDomains) > 0 {
+		caCert.PermittedDNSDomains = opts.PermittedDNSDomains
+	}
+	if len(opts.ExcludedDNSDomains) > 0 {
+		caCert.ExcludedDNSDomains = opts.ExcludedDNSDomains
+	}
+
+	if opts.MaxPathLen != nil {
+		caCert.MaxPathLen = *opts.MaxPathLen
+		caCert.MaxPathLenZero = *opts.MaxPathLen == 0
+	}
+
+	// an intermediate CA does not outlive its parent CA
+	if parentCertificate != nil && caCert.NotAfter.After(parentCertificate.NotAfter) {
+		if opts.StrictValidity {
+			return nil, ErrCAOutsideParentValidity
+		}
+		caCert.NotAfter = parentCertificate.NotAfter
+	}
+
 	signingPrivateKey := privateKey
 	if parentPrivateKey != nil {
 		signingPrivateKey = parentPrivateKey
@@ -262,7 +881,7 @@ func CreateCACert(
 	if parentCertificate != nil {
 		signingCertificate = parentCertificate
 	}
-	cert, err = x509.CreateCertificate(rand.Reader, caCert, signingCertificate, publicKey, signingPrivateKey)
+	cert, err = x509.CreateCertificate(key.Reader(), caCert, signingCertificate, publicKey, signingPrivateKey)
 	if err != nil {
 		return nil, err
 	}
@@ -273,6 +892,7 @@ func CreateCACert(
 		FileType:     storage.FileTypeCertificate,
 		CertData:     cert,
 		CreationType: creationType,
+		Location:     opts.Location,
 	}
 	err = storage.SaveFile(fileData)
 	if err != nil {
@@ -288,6 +908,7 @@ func CreateCACert(
 			FileType:     storage.FileTypeCertificate,
 			CreationType: storage.CreationTypeCertificate,
 			CertData:     cert,
+			Location:     opts.Location,
 		}
 		err = storage.SaveFile(fileData)
 		if err != nil {
@@ -303,19 +924,60 @@ func CreateCACert(
 // Using ioutil.ReadFile() satisfyies the read file.
 func LoadCert(certString []byte) (*x509.Certificate, error) {
 	block, _ := pem.Decode([]byte(string(certString)))
-	cert, _ := x509.ParseCertificate(block.Bytes)
-	return cert, nil
+	if block == nil {
+		return nil, ErrInvalidPEMCertificate
+	}
+	return x509.ParseCertificate(block.Bytes)
 }
 
 // CASignCSR signs an Certificate Signing Request and returns the Certificate as Go bytes.
 //
 // A file is also stored in $CAPATH/certs/<CSR Common Name>/<CSR Common Name>.crt
-func CASignCSR(CACommonName string, csr x509.CertificateRequest, caCert *x509.Certificate, privKey *rsa.PrivateKey, valid int, creationType storage.CreationType) (cert []byte, err error) {
-	if valid == 0 {
+func CASignCSR(CACommonName string, csr x509.CertificateRequest, caCert *x509.Certificate, privKey crypto.Signer, valid int, creationType storage.CreationType) (cert []byte, err error) {
+	return CASignCSRWithOptions(CACommonName, csr, caCert, privKey, SignOptions{Valid: valid}, creationType)
+}
+
+// CASignCSRWithOptions signs an Certificate Signing Request using the
+// SignOptions and returns the Certificate as Go bytes.
+//
+// The email addresses from the CSR Subject and Subject Alternative Name are
+// placed in the Certificate according to the SignOptions.EmailPlacement.
+//
+// A file is also stored in $CAPATH/certs/<CSR Common Name>/<CSR Common Name>.crt
+func CASignCSRWithOptions(CACommonName string, csr x509.CertificateRequest, caCert *x509.Certificate, privKey crypto.Signer, opts SignOptions, creationType storage.CreationType) (cert []byte, err error) {
+	valid := opts.Valid
+	notBefore, notAfter := opts.NotBefore, opts.NotAfter
+	if !notBefore.IsZero() || !notAfter.IsZero() {
+		if !notBefore.Before(notAfter) {
+			return nil, ErrInvalidValidityDates
+		}
+
+	} else if valid == 0 {
 		valid = DefaultValidCert
 
 	} else if valid > MaxValidCert || valid < MinValidCert {
-		return nil, errors.New("the certificate valid (min/max) is not between 1 - 825")
+		return nil, ErrInvalidValidity
+	}
+
+	if notBefore.IsZero() {
+		notBefore = time.Now()
+		notAfter = notBefore.AddDate(0, 0, valid)
+	}
+	requestedNotAfter := notAfter
+
+	if opts.NotBefore.IsZero() && opts.NotAfter.IsZero() {
+		if opts.ValidityJitter > 0 {
+			// the jitter never extends the validity over the maximum valid
+			// days and the CA certificate expiration
+			maxNotAfter := notBefore.AddDate(0, 0, MaxValidCert)
+			if caCert.NotAfter.Before(maxNotAfter) {
+				maxNotAfter = caCert.NotAfter
+			}
+			notAfter, err = jitter(notBefore, notAfter, maxNotAfter, opts.ValidityJitter)
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	fileData := storage.File{
@@ -323,35 +985,130 @@ func CASignCSR(CACommonName string, csr x509.CertificateRequest, caCert *x509.Ce
 		CommonName:   csr.Subject.CommonName,
 		FileType:     storage.FileTypeCertificate,
 		CreationType: creationType,
+		Location:     opts.Location,
 	}
 
-	if storage.CheckCertExists(fileData) {
+	if !opts.Overwrite && storage.CheckCertExists(fileData) {
 		return nil, ErrCertExists
 	}
 
-	if err != nil {
-		return nil, err
+	serialNumber := opts.SerialNumber
+	if serialNumber == nil {
+		serialNumber, err = NewSerialNumber()
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	csrTemplate := x509.Certificate{
-		Signature:          csr.Signature,
-		SignatureAlgorithm: csr.SignatureAlgorithm,
+		Signature: csr.Signature,
 
 		PublicKeyAlgorithm: csr.PublicKeyAlgorithm,
 		PublicKey:          csr.PublicKey,
 
-		SerialNumber: newSerialNumber(),
+		SerialNumber: serialNumber,
 		Issuer:       caCert.Subject,
 		Subject:      csr.Subject,
-		NotBefore:    time.Now(),
-		NotAfter:     time.Now().AddDate(0, 0, valid),
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
 		KeyUsage:     x509.KeyUsageDigitalSignature,
 		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+
+		// always assert CA=false (critical) so the certificate cannot act as
+		// a CA, even with verifiers ignoring a missing basic constraints
+		BasicConstraintsValid: true,
+		IsCA:                  false,
+	}
+
+	if opts.KeyUsage != 0 {
+		csrTemplate.KeyUsage = opts.KeyUsage
+	}
+	if len(opts.ExtKeyUsage) > 0 {
+		csrTemplate.ExtKeyUsage = opts.ExtKeyUsage
+	}
+
+	// both are encoded in the same Authority Information Access extension
+	csrTemplate.OCSPServer = opts.OCSPServers
+	csrTemplate.IssuingCertificateURL = opts.CAIssuersURLs
+	csrTemplate.CRLDistributionPoints = opts.CRLURLs
+
+	if opts.Template != nil {
+		applyTemplate(&csrTemplate, opts.Template)
+	}
+
+	if opts.BrowserCompatible && isServerAuth(csrTemplate.ExtKeyUsage) {
+		maxNotAfter := notBefore.Add(MaxBrowserValidity)
+		if requestedNotAfter.After(maxNotAfter) {
+			return nil, ErrBrowserValidity
+		}
+		// the validity jitter is clamped
+		if csrTemplate.NotAfter.After(maxNotAfter) {
+			csrTemplate.NotAfter = maxNotAfter
+		}
 	}
 
 	csrTemplate.DNSNames = csr.DNSNames
+	if !opts.KeepDNSNames {
+		csrTemplate.DNSNames = normalizeDNSNames(csr.DNSNames)
+	}
+	csrTemplate.IPAddresses = csr.IPAddresses
+	if len(csrTemplate.URIs) == 0 {
+		csrTemplate.URIs = csr.URIs
+	}
+
+	emailAddresses := csrEmailAddresses(csr)
+	if len(emailAddresses) > 0 {
+		if opts.EmailPlacement.inSubject() {
+			csrTemplate.RawSubject, err = rawSubject(csr.Subject, emailAddresses)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if opts.EmailPlacement.inSAN() {
+			csrTemplate.EmailAddresses = emailAddresses
+		}
+	}
+
+	if len(opts.PolicyOIDs) > 0 {
+		policiesExtension, err := certificatePoliciesExtension(opts.PolicyOIDs, opts.CPSURIs)
+		if err != nil {
+			return nil, err
+		}
+		csrTemplate.ExtraExtensions = append(csrTemplate.ExtraExtensions, policiesExtension)
+	}
+
+	if opts.OCSPNoCheck {
+		csrTemplate.ExtraExtensions = append(csrTemplate.ExtraExtensions, pkix.Extension{
+			Id:    oidOCSPNoCheck,
+			Value: asn1.NullBytes,
+		})
+	}
 
-	cert, err = x509.CreateCertificate(rand.Reader, &csrTemplate, caCert, csrTemplate.PublicKey, privKey)
+	if len(opts.UPNs) > 0 {
+		sanExtension, err := subjectAltNameExtension(&csrTemplate, opts.UPNs)
+		if err != nil {
+			return nil, err
+		}
+		csrTemplate.ExtraExtensions = append(csrTemplate.ExtraExtensions, sanExtension)
+	}
+
+	// the Issuer is taken from the parent subject, a copy of the CA
+	// Certificate carries the custom Issuer DN
+	parent := caCert
+	if len(opts.RawIssuer) > 0 || opts.Issuer != nil {
+		issuerCert := *caCert
+		if len(opts.RawIssuer) > 0 {
+			issuerCert.RawSubject = opts.RawIssuer
+		} else {
+			issuerCert.RawSubject, err = asn1.Marshal(opts.Issuer.ToRDNSequence())
+			if err != nil {
+				return nil, err
+			}
+		}
+		parent = &issuerCert
+	}
+
+	cert, err = x509.CreateCertificate(key.Reader(), &csrTemplate, parent, csrTemplate.PublicKey, privKey)
 	if err != nil {
 		return nil, err
 	}
@@ -369,17 +1126,44 @@ func CASignCSR(CACommonName string, csr x509.CertificateRequest, caCert *x509.Ce
 }
 
 // RevokeCertificate is used to revoke a certificate (added to the revoked list)
-func RevokeCertificate(CACommonName string, certificateList []pkix.RevokedCertificate, caCert *x509.Certificate, privKey *rsa.PrivateKey) (crl []byte, err error) {
+func RevokeCertificate(CACommonName string, certificateList []pkix.RevokedCertificate, caCert *x509.Certificate, privKey crypto.Signer) (crl []byte, err error) {
+
+	crlNumber, err := NewSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	return RevokeCertificateWithOptions(CACommonName, certificateList, caCert, privKey, CRLOptions{Number: crlNumber})
+}
+
+// RevokeCertificateWithOptions is RevokeCertificate with explicit CRLOptions.
+func RevokeCertificateWithOptions(CACommonName string, certificateList []pkix.RevokedCertificate, caCert *x509.Certificate, privKey crypto.Signer, opts CRLOptions) (crl []byte, err error) {
+
+	validity := opts.Validity
+	if validity == 0 {
+		validity = DefaultCRLValidity
+	} else if validity < 0 || validity > MaxCRLValidity {
+		return nil, ErrInvalidCRLValidity
+	}
+
+	crlNumber := opts.Number
+	if crlNumber == nil {
+		crlNumber, err = NewSerialNumber()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	thisUpdate := time.Now()
 
 	crlTemplate := x509.RevocationList{
-		SignatureAlgorithm:  caCert.SignatureAlgorithm,
 		RevokedCertificates: certificateList,
-		Number:              newSerialNumber(),
-		ThisUpdate:          time.Now(),
-		NextUpdate:          time.Now().AddDate(0, 0, 1),
+		Number:              crlNumber,
+		ThisUpdate:          thisUpdate,
+		NextUpdate:          thisUpdate.Add(validity),
 	}
 
-	crlByte, err := x509.CreateRevocationList(rand.Reader, &crlTemplate, caCert, privKey)
+	crlByte, err := x509.CreateRevocationList(key.Reader(), &crlTemplate, caCert, privKey)
 	if err != nil {
 		return nil, err
 	}
@@ -390,6 +1174,7 @@ func RevokeCertificate(CACommonName string, certificateList []pkix.RevokedCertif
 		FileType:     storage.FileTypeCRL,
 		CRLData:      crlByte,
 		CreationType: storage.CreationTypeCA,
+		Location:     opts.Location,
 	}
 
 	err = storage.SaveFile(fileData)
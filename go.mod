@@ -25,7 +25,7 @@ require (
 	github.com/urfave/cli v1.20.0 // indirect
 	go.starlark.net v0.0.0-20201210151846-e81fc95f7bd5 // indirect
 	golang.org/x/arch v0.0.0-20201207233722-1e68675e650f // indirect
-	golang.org/x/crypto v0.0.0-20220622213112-05595931fe9d // indirect
+	golang.org/x/crypto v0.0.0-20220622213112-05595931fe9d
 	golang.org/x/net v0.0.0-20220624214902-1bab6f366d9e // indirect
 	golang.org/x/sys v0.0.0-20220624220833-87e55d714810 // indirect
 	golang.org/x/tools v0.1.11 // indirect
@@ -11,23 +11,252 @@
 // variable the defines were all files (keys, certificates, etc) will be stored.
 // It is importante to have this folder in a safety place.
 //
+// The CAs created by NewWithOptions or loaded by LoadWithOptions using
+// WithPath store the files in the given path instead of the “$CAPATH“.
+// Using WithStorage the files are kept in another storage, such as the
+// in-memory storage for tests.
+//
 // GoCA also make easier manipulate files such as Private and Public Keys,
 // Certificate Signing Request, Certificate Request Lists and Certificates
 // for other Go applications.
 package goca
 
 import (
+	"context"
+	"crypto"
 	"crypto/rsa"
+	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	storage "github.com/kairoaraujo/goca/_storage"
+	"github.com/kairoaraujo/goca/cert"
+	"github.com/kairoaraujo/goca/key"
+	"golang.org/x/crypto/ocsp"
 )
 
 // CA represents the basic CA data
 type CA struct {
-	CommonName string // Certificate Authority Common Name
-	Data       CAData // Certificate Authority Data (CAData{})
+	CommonName         string               // Certificate Authority Common Name
+	Data               CAData               // Certificate Authority Data (CAData{})
+	DefaultKeyBitSize  int                  // Key Bit Size for issued certificates without Identity.KeyBitSize (default: 2048)
+	DuplicateSANPolicy DuplicateSANPolicy   // Issuing a certificate for a domain covered by another active certificate (default: allow)
+	BrowserCompatible  bool                 // Limit the TLS server certificates validity to 398 days (cert.MaxBrowserValidity)
+	SequentialSerial   bool                 // Issue consecutive serial numbers persisted in the CA serial file (default: random)
+	certPool           atomic.Value         // Cached *x509.CertPool with the CA Certificate, reset when the CA Certificate changes
+	location           storage.Location     // Base path and storage of the CA files (default: $CAPATH in the file system)
+	passphrase         []byte               // Passphrase encrypting the CA private key (default: not encrypted)
+	strictValidity     bool                 // Fail creating an intermediate CA valid after the parent CA expires (default: limited)
+	crlValidity        time.Duration        // Time from the CRL ThisUpdate to NextUpdate (default: cert.DefaultCRLValidity)
+	maxValid           int                  // Maximum valid days of the created CA certificate (default: cert.MaxValidCA)
+	serialNumber       *big.Int             // Serial number of the created CA certificate (default: random)
+	allowedDomains     []string             // Domains (and subdomains) the CA issues certificates for (default: any)
+	crlAutoReload      bool                 // Reload the CRL modified in the storage by GetCRL and GoCRL (default: false)
+	crlModTime         time.Time            // Modification time of the stored CRL when it was last loaded
+	keyEncoder         storage.KeyCodecFunc // Wraps the private keys before they are written (default: plain PEM)
+	keyDecoder         storage.KeyCodecFunc // Unwraps the private keys after they are read (default: plain PEM)
+}
+
+// Option configures a CA created by NewWithOptions, NewCAWithOptions or
+// LoadWithOptions
+type Option func(*CA)
+
+// WithPassphrase encrypts the CA private key stored in key.pem with the
+// passphrase (PKCS#8 encrypted with AES-256) when creating the CA, and
+// decrypts it when loading. The parent CA of an intermediate CA is loaded
+// with the same passphrase.
+//
+// Loading an encrypted CA private key without passphrase returns
+// key.ErrPassphraseRequired.
+func WithPassphrase(passphrase []byte) Option {
+	return func(c *CA) {
+		c.passphrase = passphrase
+	}
+}
+
+// WithStrictValidity makes the creation of an intermediate CA valid after its
+// parent CA expires fail with cert.ErrCAOutsideParentValidity. By default the
+// intermediate CA validity is limited to the parent CA validity.
+func WithStrictValidity() Option {
+	return func(c *CA) {
+		c.strictValidity = true
+	}
+}
+
+// WithSerialNumber sets the serial number of the created CA certificate,
+// instead of a random one. The serial number must be positive and at most 20
+// octets, otherwise ErrSerialNumberInvalid. An intermediate CA serial number
+// already issued by the parent CA is rejected with ErrSerialNumberExists, as
+// two certificates of the same CA never share a serial number.
+func WithSerialNumber(serialNumber *big.Int) Option {
+	return func(c *CA) {
+		c.serialNumber = serialNumber
+	}
+}
+
+// WithAllowedDomains limits the certificates issued by the CA to the domains
+// and their subdomains: the common name and the DNS names, including the
+// wildcards, must be in one of them, otherwise ErrDomainNotAllowed.
+func WithAllowedDomains(domains ...string) Option {
+	return func(c *CA) {
+		c.allowedDomains = domains
+	}
+}
+
+// WithPath stores the CA files in the path instead of the $CAPATH, allowing
+// CAs rooted at different folders in the same process.
+func WithPath(path string) Option {
+	return func(c *CA) {
+		c.location.Path = path
+	}
+}
+
+// WithCRLValidity sets the time from the CRL ThisUpdate to NextUpdate of the
+// CRLs generated by the CA (default: cert.DefaultCRLValidity). The validity
+// must be positive and at most cert.MaxCRLValidity. The validity is not
+// stored, so it is given again to LoadWithOptions.
+func WithCRLValidity(validity time.Duration) Option {
+	return func(c *CA) {
+		c.crlValidity = validity
+	}
+}
+
+// WithCRLAutoReload makes GetCRL and GoCRL reload the CRL when the stored CRL
+// was modified after it was loaded, e.g. regenerated by another process
+// sharing the CAPATH. See also CA.ReloadCRL.
+//
+// A stored CRL failing to load (e.g. corrupt) is logged and the current CRL
+// is kept; ReloadCRL returns the error.
+func WithCRLAutoReload() Option {
+	return func(c *CA) {
+		c.crlAutoReload = true
+	}
+}
+
+// WithMaxValidity sets the maximum valid days (Identity.Valid) of the CA
+// certificate created (default: cert.MaxValidCA). The issued certificates
+// are limited to cert.MaxValidCert days.
+func WithMaxValidity(days int) Option {
+	return func(c *CA) {
+		c.maxValid = days
+	}
+}
+
+// WithStorage stores the CA files in the storage instead of the file system,
+// e.g. storage.NewMemory() for tests. The files keep their paths under the CA
+// path (WithPath or $CAPATH) inside the storage, so the CAs given the same
+// storage find each other (e.g. the parent of an intermediate CA). Only the
+// CAs given the storage use it.
+func WithStorage(s storage.Storage) Option {
+	return func(c *CA) {
+		c.location.Storage = s
+	}
+}
+
+// WithDirectoryPermission sets the permission used by the CA to create its
+// folders in the file system, such as the CA "ca" and "certs" folders and the
+// folders of the issued certificates (default: 0755). Use 0700 for hardened
+// multi-user hosts. The other CAs in the same path are not affected.
+func WithDirectoryPermission(perm os.FileMode) Option {
+	return func(c *CA) {
+		c.location.DirPermission = perm
+	}
+}
+
+// WithFileNameFunc sets the hook used to name the files of the CA and of its
+// certificates, for example to use tls.key and tls.crt (default:
+// storage.DefaultFileName). The other CAs in the same path are not affected.
+//
+// The same hook must be used to create and to load the CAs and certificates.
+func WithFileNameFunc(fileName storage.FileNameFunc) Option {
+	return func(c *CA) {
+		c.location.FileName = fileName
+	}
+}
+
+// WithKeyCodec sets the hooks wrapping the private keys of the CA and of its
+// certificates before they are written (encoder) and unwrapping them after
+// they are read (decoder), for example envelope encryption with a KMS held
+// master key. GoCA is agnostic about the wrapping. Without the option the
+// plain PEM private keys are stored.
+//
+// The same hooks must be used to create and to load the CAs and certificates.
+func WithKeyCodec(encoder, decoder storage.KeyCodecFunc) Option {
+	return func(c *CA) {
+		c.keyEncoder = encoder
+		c.keyDecoder = decoder
+	}
+}
+
+// applyOptions configures the CA with the options
+func (c *CA) applyOptions(options []Option) {
+	for _, option := range options {
+		option(c)
+	}
+}
+
+// DuplicateSANPolicy represents the behavior of IssueCertificate when the new
+// certificate covers a domain already covered by another active certificate
+type DuplicateSANPolicy int
+
+const (
+	// DuplicateSANAllow issues the certificate (default)
+	DuplicateSANAllow DuplicateSANPolicy = iota
+	// DuplicateSANWarn issues the certificate and logs the conflicting certificates
+	DuplicateSANWarn
+	// DuplicateSANDeny refuses the certificate with a *DuplicateSANError
+	DuplicateSANDeny
+)
+
+// DuplicateSANError is returned by IssueCertificate with DuplicateSANDeny
+type DuplicateSANError struct {
+	Domain      string   // Domain already covered
+	CommonNames []string // Common Names of the active certificates covering the domain
+}
+
+func (e *DuplicateSANError) Error() string {
+	return "the domain " + e.Domain + " is covered by the active certificates: " + strings.Join(e.CommonNames, ", ")
+}
+
+// CorruptCertificatesError is returned by ListExpiringCertificates, with the
+// certificates found, when some certificate files can not be parsed
+type CorruptCertificatesError struct {
+	CommonNames []string // Common Names of the certificates that can not be parsed
+}
+
+func (e *CorruptCertificatesError) Error() string {
+	return "the certificates can not be parsed: " + strings.Join(e.CommonNames, ", ")
+}
+
+// Certificate fields compared by DiffCertificates
+const (
+	FieldSubject      = "subject"
+	FieldSANs         = "sans"
+	FieldKeyUsage     = "key_usage"
+	FieldExtKeyUsage  = "ext_key_usage"
+	FieldPublicKey    = "public_key"
+	FieldNotBefore    = "not_before"
+	FieldNotAfter     = "not_after"
+	FieldSerialNumber = "serial_number"
+)
+
+// FieldDiff represents a certificate field that differs between two
+// certificates
+type FieldDiff struct {
+	Field string `json:"field"` // Field name, one of the Field constants
+	A     string `json:"a"`     // Field value in the first certificate
+	B     string `json:"b"`     // Field value in the second certificate
 }
 
 // Certificate represents a Certificate data
@@ -40,9 +269,113 @@ type Certificate struct {
 	CACertificate string                  `json:"ca_certificate" example:"-----BEGIN CERTIFICATE-----...-----END CERTIFICATE-----\n"`      // CA Certificate as string
 	privateKey    rsa.PrivateKey          // Certificate Private Key object rsa.PrivateKey
 	publicKey     rsa.PublicKey           // Certificate Private Key object rsa.PublicKey
+	signer        crypto.Signer           // Certificate Private Key object of any algorithm
+	public        crypto.PublicKey        // Certificate Public Key object of any algorithm
 	csr           x509.CertificateRequest // Certificate Sigining Request object x509.CertificateRequest
 	certificate   *x509.Certificate       // Certificate certificate *x509.Certificate
 	caCertificate *x509.Certificate       // CA Certificate *x509.Certificate
+	location      storage.Location        // Base path and storage of the CA files (default: $CAPATH in the file system)
+}
+
+// jsonCertificate is the Certificate without its methods, marshalled as JSON
+// by the default encoding
+type jsonCertificate Certificate
+
+// MarshalJSON encodes the Certificate as PublicJSON, omitting the private
+// key. Use SecretJSON to include it.
+func (c Certificate) MarshalJSON() ([]byte, error) {
+	return c.PublicJSON()
+}
+
+// PublicJSON returns the Certificate JSON encoded without the private key
+func (c Certificate) PublicJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		jsonCertificate
+		PrivateKey string `json:"private_key,omitempty"`
+	}{jsonCertificate: jsonCertificate(c)})
+}
+
+// SecretJSON returns the Certificate JSON encoded with the private key. Keep
+// the result as secret as the private key.
+func (c Certificate) SecretJSON() ([]byte, error) {
+	return json.Marshal(jsonCertificate(c))
+}
+
+// CertStatus represents the status of a certificate managed by the CA
+type CertStatus int
+
+const (
+	// CertStatusActive is a certificate not revoked and not expired
+	CertStatusActive CertStatus = iota
+	// CertStatusRevoked is a certificate in the Certificate Revocation List
+	CertStatusRevoked
+	// CertStatusExpired is a certificate not revoked but expired
+	CertStatusExpired
+)
+
+// String returns the status name
+func (s CertStatus) String() string {
+	switch s {
+	case CertStatusActive:
+		return "active"
+	case CertStatusRevoked:
+		return "revoked"
+	case CertStatusExpired:
+		return "expired"
+	default:
+		return "unknown"
+	}
+}
+
+// StatusManifestEntry represents a certificate in the StatusManifest
+type StatusManifestEntry struct {
+	CommonName   string    `json:"common_name" example:"intranet.example.com"`
+	SerialNumber string    `json:"serial_number" example:"338255903472757769326153358304310617728"`
+	NotBefore    time.Time `json:"not_before" example:"2021-01-06T10:31:43Z"`
+	NotAfter     time.Time `json:"not_after" example:"2022-01-06T10:31:43Z"`
+	Status       string    `json:"status" example:"active"`
+}
+
+// CertificateInfo represents an issued certificate listed by
+// ListCertificatesInfo
+type CertificateInfo struct {
+	CommonName string    `json:"common_name" example:"intranet.example.com"`
+	NotAfter   time.Time `json:"not_after" example:"2022-01-06T10:31:43Z"`
+	Revoked    bool      `json:"revoked" example:"false"`
+}
+
+// StatusManifest represents all certificates issued by the CA and their
+// current status
+type StatusManifest struct {
+	CommonName   string                `json:"common_name" example:"root-ca"`
+	GeneratedAt  time.Time             `json:"generated_at" example:"2021-01-06T10:31:43Z"`
+	Certificates []StatusManifestEntry `json:"certificates"`
+}
+
+// SignedStatusManifest is the StatusManifest signed by the CA private key
+//
+// The Signature is a SHA-256 RSA PKCS #1 v1.5 signature over the Manifest
+// bytes as they are.
+type SignedStatusManifest struct {
+	Manifest  json.RawMessage `json:"manifest"`
+	Signature []byte          `json:"signature"`
+}
+
+// MinRSAKeyBitSize is the minimum RSA key size not reported as weak
+const MinRSAKeyBitSize int = 2048
+
+// CSRPolicy represents the requirements a CSR must meet to be signed, checked
+// by CA.ValidateCSR
+type CSRPolicy struct {
+	RequiredDNSNames      []string                // DNS names the CSR must request (case-insensitive)
+	ForbiddenExtKeyUsages []asn1.ObjectIdentifier // Extended key usages the CSR must not request
+	MinRSAKeyBitSize      int                     // Minimum RSA key size (default: MinRSAKeyBitSize)
+}
+
+// KeyAuditReport represents the result of the CA keys audit
+type KeyAuditReport struct {
+	ReusedKeys map[string][]string // Common Names sharing the same public key, by key fingerprint
+	WeakKeys   []string            // Common Names using RSA keys smaller than MinRSAKeyBitSize
 }
 
 //
@@ -51,9 +384,16 @@ type Certificate struct {
 
 // Load an existent Certificate Authority from $CAPATH
 func Load(commonName string) (ca CA, err error) {
+	return LoadWithOptions(commonName)
+}
+
+// LoadWithOptions loads an existent Certificate Authority configured by the
+// options, such as WithPath.
+func LoadWithOptions(commonName string, options ...Option) (ca CA, err error) {
 	ca = CA{
 		CommonName: commonName,
 	}
+	ca.applyOptions(options)
 
 	err = ca.loadCA(commonName)
 	if err != nil {
@@ -64,22 +404,166 @@ func Load(commonName string) (ca CA, err error) {
 
 }
 
-// List list all existent Certificate Authorities in $CAPATH
+// LoadAndRefresh loads an existent Certificate Authority from $CAPATH and
+// regenerates the Certificate Revocation List when it is expired (NextUpdate
+// has passed), keeping the revoked certificates.
+//
+// The CRL is only signed again when the CA private key is available and the
+// CA certificate is allowed to sign CRLs. The options are the options of
+// LoadWithOptions, e.g. WithPath, WithPassphrase or WithCRLValidity.
+func LoadAndRefresh(commonName string, options ...Option) (ca CA, err error) {
+	ca, err = LoadWithOptions(commonName, options...)
+	if err != nil {
+		return CA{}, err
+	}
+
+	if ca.Data.signer == nil || ca.Data.certificate == nil || !crlSigner(ca.Data.certificate) {
+		return ca, nil
+	}
+
+	crl := ca.GoCRL()
+	if crl == nil || crl.HasExpired(time.Now()) {
+		err = ca.RefreshCRL()
+		if err != nil {
+			return CA{}, err
+		}
+	}
+
+	return ca, nil
+}
+
+// List list all existent Certificate Authorities in $CAPATH, sorted. Folders
+// without the CA certificate (ca/<CA Common Name>.crt) are not CAs and are
+// skipped.
 func List() []string {
-	return storage.ListCAs()
+	return listCAs(storage.Location{})
+}
+
+// Delete removes the Certificate Authority from the $CAPATH, with all its
+// files and issued certificates. It returns ErrCALoadNotFound if the CA does
+// not exist. Symbolic links inside the CA folder are removed, not followed.
+//
+// The options, such as WithPath, select where the CA is stored. The deletion
+// can not be undone: use DeletePaths to confirm the files removed.
+func Delete(commonName string, options ...Option) error {
+	ca := CA{
+		CommonName: commonName,
+	}
+	ca.applyOptions(options)
+
+	return ca.delete()
+}
+
+// DeletePaths returns the paths of the files removed by Delete, relative to
+// the $CAPATH (or the WithPath path), without removing them (dry run).
+func DeletePaths(commonName string, options ...Option) ([]string, error) {
+	ca := CA{
+		CommonName: commonName,
+	}
+	ca.applyOptions(options)
+
+	return ca.deletePaths()
+}
+
+// CASize returns the total size in bytes of the Certificate Authority files in
+// $CAPATH and the number of issued certificates.
+//
+// The options, such as WithPath or WithStorage, select where the CA is stored.
+func CASize(commonName string, options ...Option) (size int64, certificates int, err error) {
+	ca := CA{
+		CommonName: commonName,
+	}
+	ca.applyOptions(options)
+
+	return ca.size()
 }
 
-// New creat new Certificate Authority
+// ErrTestModeNotAllowed means that the test mode requires GOCATEST=true
+var ErrTestModeNotAllowed = errors.New("the test mode is allowed only with GOCATEST=true")
+
+// SetTestMode enables (or disables) the deterministic test mode: serial
+// numbers are sequential starting at 1 and a fixed random source is used for
+// keys and signatures, making the output reproducible for golden-file tests.
+//
+// The test mode is allowed only when the environment variable GOCATEST is
+// "true" and must never be used in production, the keys are predictable.
+func SetTestMode(enabled bool) error {
+	if enabled && os.Getenv("GOCATEST") != "true" {
+		return ErrTestModeNotAllowed
+	}
+
+	cert.SetTestMode(enabled)
+
+	return nil
+}
+
+// New creates a new Root Certificate Authority from the identity alone.
+//
+// The CA certificate is built from the identity Organization,
+// OrganizationalUnit, Country, Locality and Province (all required),
+// EmailAddresses, DNSNames, Valid, KeyAlgorithm, KeyBitSize,
+// PermittedDNSDomains, ExcludedDNSDomains and MaxPathLen. Intermediate must be
+// false, see NewCA. The other fields only apply to the issued certificates.
+//
+// The identity is stored in ca/identity.json, its subject details are the
+// defaults of the certificates issued from CSRs without subject details.
 func New(commonName string, identity Identity) (ca CA, err error) {
 	ca, err = NewCA(commonName, "", identity)
 	return ca, err
 }
 
-// New create a new Certificate Authority
+// NewFromIdentity creates a new Root Certificate Authority from the identity,
+// as New.
+func NewFromIdentity(commonName string, id Identity) (CA, error) {
+	return New(commonName, id)
+}
+
+// NewCA creates a new Certificate Authority from the identity, as New. With
+// Intermediate the CA is an Intermediate CA signed by the parent CA, loaded
+// from the same path, otherwise the CA is a Root CA.
 func NewCA(commonName, parentCommonName string, identity Identity) (ca CA, err error) {
+	return NewCAWithOptions(commonName, parentCommonName, identity)
+}
+
+// NewWithOptions creates a new Root Certificate Authority configured by the
+// options, such as WithPath.
+func NewWithOptions(commonName string, identity Identity, options ...Option) (ca CA, err error) {
+	return NewCAWithOptions(commonName, "", identity, options...)
+}
+
+// ImportCA adopts a Certificate Authority created elsewhere (e.g. by openssl)
+// from its PEM encoded certificate and private key, storing them in the
+// $CAPATH with an empty CRL. The CA is then loaded by Load as any other CA.
+//
+// It returns ErrCertNotCA if the certificate is not a CA certificate and
+// ErrCAKeyMismatch if the private key does not match the certificate.
+func ImportCA(commonName string, certPEM, keyPEM []byte) (ca CA, err error) {
+	return ImportCAWithOptions(commonName, certPEM, keyPEM)
+}
+
+// ImportCAWithOptions is ImportCA configured by the options, such as WithPath
+// or WithPassphrase encrypting the stored private key.
+func ImportCAWithOptions(commonName string, certPEM, keyPEM []byte, options ...Option) (ca CA, err error) {
+	ca = CA{
+		CommonName: commonName,
+	}
+	ca.applyOptions(options)
+
+	err = ca.importCA(commonName, certPEM, keyPEM)
+	if err != nil {
+		return CA{}, err
+	}
+
+	return ca, nil
+}
+
+// NewCAWithOptions creates a new Certificate Authority configured by the
+// options, such as WithPath. The parent CA is loaded from the same path.
+func NewCAWithOptions(commonName, parentCommonName string, identity Identity, options ...Option) (ca CA, err error) {
 	ca = CA{
 		CommonName: commonName,
 	}
+	ca.applyOptions(options)
 
 	err = ca.create(commonName, parentCommonName, identity)
 	if err != nil {
@@ -89,6 +573,53 @@ func NewCA(commonName, parentCommonName string, identity Identity) (ca CA, err e
 	return ca, nil
 }
 
+// CreateConstrainedSubCA creates a new Intermediate Certificate Authority signed
+// by this CA, with Name Constraints permitting only the permittedDomains (and
+// their subdomains). The sub CA uses this CA subject details and options
+// (path, storage, passphrase, key codec, file names, CRL validity and folders
+// permission).
+func (c *CA) CreateConstrainedSubCA(commonName string, permittedDomains []string, valid int) (ca CA, err error) {
+	if c.Data.certificate == nil {
+		return CA{}, ErrCertInvalid
+	}
+
+	subject := c.Data.certificate.Subject
+	id := Identity{
+		Organization:        firstOrEmpty(subject.Organization),
+		OrganizationalUnit:  firstOrEmpty(subject.OrganizationalUnit),
+		Country:             firstOrEmpty(subject.Country),
+		Locality:            firstOrEmpty(subject.Locality),
+		Province:            firstOrEmpty(subject.Province),
+		Intermediate:        true,
+		Valid:               valid,
+		PermittedDNSDomains: permittedDomains,
+	}
+
+	return NewCAWithOptions(commonName, c.CommonName, id, c.inheritedOptions()...)
+}
+
+// inheritedOptions returns the options of the CA given to its sub CAs, so they
+// are stored and their keys are wrapped the same way
+func (c *CA) inheritedOptions() []Option {
+	return []Option{
+		WithPath(c.location.Path),
+		WithStorage(c.location.Storage),
+		WithDirectoryPermission(c.location.DirPermission),
+		WithFileNameFunc(c.location.FileName),
+		WithKeyCodec(c.keyEncoder, c.keyDecoder),
+		WithCRLValidity(c.crlValidity),
+		WithPassphrase(c.passphrase),
+	}
+}
+
+func firstOrEmpty(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
 // GetPublicKey returns the PublicKey as string
 func (c *CA) GetPublicKey() string {
 	return c.Data.PublicKey
@@ -109,6 +640,55 @@ func (c *CA) GoPublicKey() rsa.PublicKey {
 	return c.Data.publicKey
 }
 
+// GoSigner returns the Private Key as crypto.Signer, available for all key
+// algorithms (GoPrivateKey is only filled for RSA)
+func (c *CA) GoSigner() crypto.Signer {
+	return c.Data.signer
+}
+
+// GoPublic returns the Public Key as crypto.PublicKey, available for all key
+// algorithms (GoPublicKey is only filled for RSA)
+func (c *CA) GoPublic() crypto.PublicKey {
+	return c.Data.public
+}
+
+// ExportPrivateKey returns the Private Key encoded as PKCS#8 PEM (PRIVATE KEY)
+// for all key algorithms, or ErrCAMissingPrivateKey if not available.
+func (c *CA) ExportPrivateKey() ([]byte, error) {
+	if c.Data.signer == nil {
+		return nil, ErrCAMissingPrivateKey
+	}
+
+	return key.MarshalPKCS8PEM(c.Data.signer)
+}
+
+// ExportPrivateKeyDER returns the Private Key encoded as PKCS#8 DER, or
+// ErrCAMissingPrivateKey if not available.
+func (c *CA) ExportPrivateKeyDER() ([]byte, error) {
+	if c.Data.signer == nil {
+		return nil, ErrCAMissingPrivateKey
+	}
+
+	return x509.MarshalPKCS8PrivateKey(c.Data.signer)
+}
+
+// ExportEncryptedPrivateKey returns the Private Key encoded as PKCS#8 PEM
+// encrypted with the passphrase (ENCRYPTED PRIVATE KEY), or
+// ErrCAMissingPrivateKey if not available.
+func (c *CA) ExportEncryptedPrivateKey(passphrase []byte) ([]byte, error) {
+	if c.Data.signer == nil {
+		return nil, ErrCAMissingPrivateKey
+	}
+
+	return key.MarshalEncryptedPKCS8PEM(c.Data.signer, passphrase)
+}
+
+// Identity returns the Identity used to create the CA. For CAs created without
+// the Identity stored, it is derived from the CA Certificate.
+func (c *CA) Identity() Identity {
+	return c.Data.identity
+}
+
 // GetCSR returns the Certificate Signing Request as string
 func (c *CA) GetCSR() string {
 	return c.Data.CSR
@@ -131,12 +711,45 @@ func (c *CA) GoCertificate() *x509.Certificate {
 
 // GetCRL returns Certificate Revocation List as x509 CRL string
 func (c *CA) GetCRL() string {
-	return c.Data.CRL
+	crlString, _ := c.loadedCRL()
+
+	return crlString
 }
 
 // GoCRL returns Certificate Revocation List as Go bytes *pkix.CertificateList
 func (c *CA) GoCRL() *pkix.CertificateList {
-	return c.Data.crl
+	_, crl := c.loadedCRL()
+
+	return crl
+}
+
+// ReloadCRL loads the stored CRL again, refreshing GetCRL and GoCRL after the
+// CRL is modified outside of the loaded CA, e.g. by another process sharing
+// the CAPATH.
+func (c *CA) ReloadCRL() error {
+	return c.reloadCRL()
+}
+
+// GetCACertificatePool returns a CertPool with the CA Certificate and, for an
+// intermediate CA, the parent CA certificates up to the root CA certificate,
+// e.g. for the tls.Config RootCAs or ClientCAs. The parent CA certificates not
+// found are not included.
+func (c *CA) GetCACertificatePool() *x509.CertPool {
+	return c.caCertificatePool()
+}
+
+// CAChainBundle returns the CA Certificate followed by the parent CA
+// certificates up to the root CA certificate as a PEM bundle, to distribute the
+// trust chain to clients (e.g. published at the AIA URL). For a root CA it is
+// only the root CA certificate.
+func (c *CA) CAChainBundle() string {
+	var bundle string
+
+	for _, caCertificate := range certificateChain(c.location, c.Data.certificate, true) {
+		bundle += string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCertificate.Raw}))
+	}
+
+	return bundle
 }
 
 // IsIntermediate returns if the CA is Intermediate CA (true)
@@ -145,9 +758,144 @@ func (c *CA) IsIntermediate() bool {
 
 }
 
+// NeedsRenewal returns if the CA Certificate is within the renewal window,
+// meaning that now is after the NotAfter minus the threshold.
+//
+// A missing or expired CA Certificate also needs renewal.
+func (c *CA) NeedsRenewal(threshold time.Duration) bool {
+	certificate := c.GoCertificate()
+	if certificate == nil {
+		return true
+	}
+
+	return time.Now().After(certificate.NotAfter.Add(-threshold))
+}
+
 // ListCertificates returns all certificates in the CA
 func (c *CA) ListCertificates() []string {
-	return storage.ListCertificates(c.CommonName)
+	return storage.ListCertificatesIn(c.location, c.CommonName)
+}
+
+// ListCertificatesFiltered returns the certificates in the CA with the common
+// name starting with the prefix.
+func (c *CA) ListCertificatesFiltered(prefix string) []string {
+	return c.listCertificatesFiltered(prefix)
+}
+
+// ListCertificatesInfo returns the common name, expiration and revocation
+// status, against the current CRL, of the certificates in the CA with the
+// common name starting with the prefix (empty lists all the certificates).
+// Certificate folders without certificate are skipped.
+func (c *CA) ListCertificatesInfo(prefix string) ([]CertificateInfo, error) {
+	return c.listCertificatesInfo(prefix)
+}
+
+// ListExpiringCertificates returns the certificates in the CA expiring within
+// the duration from now, including the expired ones, e.g. to renew them with
+// RenewCertificate. Revoked certificates are skipped.
+//
+// The scan continues over the certificate files that can not be parsed,
+// returning the certificates found with a *CorruptCertificatesError.
+func (c *CA) ListExpiringCertificates(within time.Duration) ([]Certificate, error) {
+	return c.listExpiringCertificates(within)
+}
+
+// ListCertificatesByStatus returns the certificates in the CA filtered by the
+// status (CertStatusActive, CertStatusRevoked or CertStatusExpired).
+//
+// Revoked certificates are reported as CertStatusRevoked even if expired.
+func (c *CA) ListCertificatesByStatus(status CertStatus) ([]string, error) {
+	return c.listCertificatesByStatus(status)
+}
+
+// NewOCSPRequest returns the OCSP request (issuer name and key hashes and the
+// serial number) for a certificate issued by the CA, to check the certificate
+// status against an OCSP responder right after the issuance.
+func (c *CA) NewOCSPRequest(commonName string) (*ocsp.Request, error) {
+	return c.ocspRequest(commonName)
+}
+
+// OCSPResponse returns the OCSP response signed by the CA for the serial
+// number: revoked, with the revocation time and reason of the CRL entry, good
+// if issued by the CA, or unknown otherwise.
+func (c *CA) OCSPResponse(serial *big.Int) ([]byte, error) {
+	return c.ocspResponse(serial)
+}
+
+// OCSPHandler returns an OCSP responder HTTP handler answering the GET and
+// POST OCSP requests (RFC 6960) with OCSPResponse. The handler is mounted at
+// the root path, see OCSPHandlerWithPrefix.
+func (c *CA) OCSPHandler() http.Handler {
+	return ocspHandler{ca: c}
+}
+
+// OCSPHandlerWithPrefix is OCSPHandler mounted at the path prefix, e.g.
+// "/ocsp/", which is stripped from the GET OCSP requests.
+func (c *CA) OCSPHandlerWithPrefix(prefix string) http.Handler {
+	return ocspHandler{ca: c, prefix: prefix}
+}
+
+// CertificatesForDomain returns the Common Names of the issued certificates
+// valid for the domain (DNS name or IP address) by the x509 hostname matching
+// rules, including wildcard DNS names. Revoked and expired certificates are
+// included.
+func (c *CA) CertificatesForDomain(domain string) ([]string, error) {
+	return c.certificatesForDomain(domain)
+}
+
+// CertificatesIssuedBetween returns the common names of the certificates
+// issued by the CA with NotBefore from start, inclusive, to end, exclusive.
+// The certificates that can not be parsed are skipped with a logged warning.
+func (c *CA) CertificatesIssuedBetween(start, end time.Time) ([]string, error) {
+	return c.certificatesIssuedBetween(start, end)
+}
+
+// AuditKeys scans the certificates issued by the CA and reports public keys
+// reused across certificates and weak keys.
+func (c *CA) AuditKeys() (*KeyAuditReport, error) {
+	return c.auditKeys()
+}
+
+// StatusManifest returns a JSON document (SignedStatusManifest) listing every
+// certificate issued by the CA with the serial number, validity and current
+// revocation status, signed by the CA private key.
+//
+// Clients verify it using VerifyStatusManifest and the CA Certificate.
+func (c *CA) StatusManifest() ([]byte, error) {
+	return c.statusManifest()
+}
+
+// VerifyStatusManifest verifies the SignedStatusManifest signature using the
+// CA Certificate public key and returns the StatusManifest. A nil CA
+// Certificate returns ErrCertInvalid.
+func VerifyStatusManifest(signedManifest []byte, caCertificate *x509.Certificate) (manifest StatusManifest, err error) {
+	var signed SignedStatusManifest
+
+	if caCertificate == nil {
+		return manifest, ErrCertInvalid
+	}
+
+	if err = json.Unmarshal(signedManifest, &signed); err != nil {
+		return manifest, err
+	}
+
+	if err = verifyManifest(caCertificate.PublicKey, signed.Manifest, signed.Signature); err != nil {
+		return manifest, ErrManifestSignature
+	}
+
+	err = json.Unmarshal(signed.Manifest, &manifest)
+
+	return manifest, err
+}
+
+// DiffCertificates compares two certificates, such as a re-issued certificate
+// and the original, and returns the fields that differ: subject, SANs, key
+// usage, extended key usage, public key, validity and serial number.
+//
+// A renewal preserving the identity differs only in the validity and serial
+// number.
+func DiffCertificates(a, b *x509.Certificate) ([]FieldDiff, error) {
+	return diffCertificates(a, b)
 }
 
 // Status get details about Certificate Authority status.
@@ -166,10 +914,33 @@ func (c *CA) Status() string {
 	}
 }
 
-// SignCSR perform a creation of certificate from a CSR (x509.CertificateRequest) and returns *x509.Certificate
+// Freeze stops the CA from issuing certificates, returning ErrCAFrozen, until
+// Unfreeze. The revocation, the CRL and the reads still work. The freeze is
+// persisted in the CA directory, so it survives a reload and applies to all
+// the processes sharing the $CAPATH.
+func (c *CA) Freeze() error {
+	return c.freeze()
+}
+
+// Unfreeze allows a frozen CA to issue certificates again.
+func (c *CA) Unfreeze() error {
+	return c.unfreeze()
+}
+
+// IsFrozen returns if the CA is frozen against issuance.
+func (c *CA) IsFrozen() bool {
+	return caFrozen(c.location, c.CommonName)
+}
+
+// SignCSR signs an externally generated CSR (x509.CertificateRequest), e.g. by
+// a client keeping its private key, and returns the Certificate.
+//
+// No key pair is created: only the certificate is stored under
+// certs/<common name>/, so it can be revoked later, and the returned
+// Certificate has no private or public key.
 func (c *CA) SignCSR(csr x509.CertificateRequest, valid int) (certificate Certificate, err error) {
 
-	certificate, err = c.signCSR(csr, valid)
+	certificate, err = c.signCSR(csr, cert.SignOptions{Valid: valid})
 
 	return certificate, err
 
@@ -180,11 +951,180 @@ func (c *CA) SignCSR(csr x509.CertificateRequest, valid int) (certificate Certif
 // It is import create an Identity{} with Certificate Client/Server information.
 func (c *CA) IssueCertificate(commonName string, id Identity) (certificate Certificate, err error) {
 
-	certificate, err = c.issueCertificate(commonName, id)
+	certificate, err = c.IssueCertificateContext(context.Background(), commonName, id)
+
+	return certificate, err
+}
+
+// IssueCertificateContext is IssueCertificate returning ctx.Err() if the
+// context is cancelled or its deadline passes before the certificate is
+// signed, e.g. during a slow RSA key generation. The files already written
+// for the certificate are removed.
+func (c *CA) IssueCertificateContext(ctx context.Context, commonName string, id Identity) (certificate Certificate, err error) {
+	return c.issueCertificate(ctx, commonName, id)
+}
+
+// IssueCertificateFromTemplate is IssueCertificate with the certificate fields
+// of the template, such as KeyUsage, ExtKeyUsage, BasicConstraints and
+// ExtraExtensions (e.g. a code signing certificate). The zero fields get the
+// IssueCertificate defaults.
+//
+// The template subject, DNS names, IP addresses and first email address are
+// requested in the CSR, and its NotBefore/NotAfter are the validity if
+// NotAfter is set. The key pair has keyBits bits (0 is the CA
+// DefaultKeyBitSize), and the serial number and issuer are set by the CA.
+func (c *CA) IssueCertificateFromTemplate(commonName string, tmpl *x509.Certificate, keyBits int) (certificate Certificate, err error) {
+	if tmpl == nil {
+		tmpl = &x509.Certificate{}
+	}
+
+	id := Identity{
+		Organization:        firstOrEmpty(tmpl.Subject.Organization),
+		OrganizationalUnit:  firstOrEmpty(tmpl.Subject.OrganizationalUnit),
+		Country:             firstOrEmpty(tmpl.Subject.Country),
+		Locality:            firstOrEmpty(tmpl.Subject.Locality),
+		Province:            firstOrEmpty(tmpl.Subject.Province),
+		EmailAddresses:      firstOrEmpty(tmpl.EmailAddresses),
+		SubjectSerialNumber: tmpl.Subject.SerialNumber,
+		DNSNames:            tmpl.DNSNames,
+		IPAddresses:         tmpl.IPAddresses,
+		KeyBitSize:          keyBits,
+	}
+
+	return c.issueCertificateFromTemplate(context.Background(), commonName, id, tmpl)
+}
+
+// IssueCertificateWithDates creates a new certificate from a CSR valid exactly
+// from notBefore to notAfter.
+//
+// The CSR subject, DNS names, IP addresses, email addresses and URIs are used.
+// The subject country, province, locality, organization and organizational
+// unit missing in the CSR are taken from the CA Identity.
+//
+// The dates must be within the CA Certificate validity. A nil CSR returns
+// ErrCSRMissing.
+func (c *CA) IssueCertificateWithDates(commonName string, csr *x509.CertificateRequest, notBefore, notAfter time.Time) (certificate Certificate, err error) {
+	if csr == nil {
+		return certificate, ErrCSRMissing
+	}
+
+	if !notBefore.Before(notAfter) {
+		return certificate, cert.ErrInvalidValidityDates
+	}
+
+	if c.Data.certificate == nil || notBefore.Before(c.Data.certificate.NotBefore) || notAfter.After(c.Data.certificate.NotAfter) {
+		return certificate, ErrCertOutsideCAValidity
+	}
+
+	signCSR := *csr
+	signCSR.Subject = c.csrSubject(commonName, csr.Subject)
+
+	certificate, err = c.signCSR(signCSR, cert.SignOptions{NotBefore: notBefore, NotAfter: notAfter})
 
 	return certificate, err
 }
 
+// ValidateCSR checks the CSR signature and the CSRPolicy (requested DNS names,
+// extended key usages and key strength) and returns the first violation.
+//
+// The CSR is not signed.
+func (c *CA) ValidateCSR(csr *x509.CertificateRequest, policy CSRPolicy) error {
+	return validateCSR(csr, policy)
+}
+
+// IssueCertificateRaw issues a certificate and returns the certificate, the CA
+// certificate chain (up to the root) and the private key as PEM strings.
+//
+// When csr is nil the key pair is created by the CA, otherwise the CSR is
+// signed and the private key is empty as it is owned by the requester. The
+// CSR subject fields are used as IssueCertificateWithDates.
+func (c *CA) IssueCertificateRaw(commonName string, csr *x509.CertificateRequest, valid int) (certPEM, chainPEM, keyPEM string, err error) {
+	var certificate Certificate
+
+	if csr == nil {
+		certificate, err = c.issueCertificate(context.Background(), commonName, Identity{Valid: valid})
+	} else {
+		signCSR := *csr
+		signCSR.Subject = c.csrSubject(commonName, csr.Subject)
+		certificate, err = c.signCSR(signCSR, cert.SignOptions{Valid: valid})
+	}
+	if err != nil {
+		return "", "", "", err
+	}
+
+	certPEM = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.certificate.Raw}))
+	for _, caCertificate := range certificateChain(c.location, c.Data.certificate, true) {
+		chainPEM += string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCertificate.Raw}))
+	}
+
+	return certPEM, chainPEM, certificate.PrivateKey, nil
+}
+
+// VerifyCertificate verifies that the certificate was issued by the
+// Certificate Authority, with its chain up to the root CA certificate, and is
+// currently valid and not revoked in the CA CRL.
+//
+// It returns ErrCertExpired, ErrCertUntrusted or ErrCertRevoked.
+func (c *CA) VerifyCertificate(certificate *x509.Certificate) error {
+	return c.verifyCertificate(certificate)
+}
+
+// Verify verifies a certificate managed by the Certificate Authority against
+// the CA Certificate and the Certificate Revocation List.
+func (c *CA) Verify(commonName string) error {
+	certificate, err := c.loadCertificateMeta(commonName)
+	if err != nil {
+		return err
+	}
+
+	return c.verify(certificate)
+}
+
+// VerifyAll verifies all certificates managed by the Certificate Authority
+// concurrently and returns the result by certificate common name.
+//
+// A nil error means the certificate is valid. With WithCRLAutoReload, the
+// error of a stored CRL failing to reload is the result of every certificate.
+func (c *CA) VerifyAll() map[string]error {
+	return c.verifyAll(runtime.NumCPU())
+}
+
+// RenewCertificate renews a certificate managed by the Certificate Authority
+// keeping its key pair: the stored CSR is signed again with a new serial
+// number and valid days, keeping the subject and SANs, and the certificate file
+// is replaced.
+//
+// It returns ErrCertLoadNotFound if the certificate does not exist,
+// ErrCertRevoked if it is revoked and ErrCSRNotFound if its CSR is not stored.
+func (c *CA) RenewCertificate(commonName string, valid int) (certificate Certificate, err error) {
+	return c.renewCertificate(commonName, valid)
+}
+
+// ReissueAll re-issues all certificates managed by the Certificate Authority
+// under the current CA Certificate, for example after the CA renewal.
+//
+// For each certificate with a stored private key a new CSR is generated
+// keeping the subject and SANs, and signed again with valid days. The results
+// are by certificate common name, where a nil error means re-issued.
+// Certificates without private key (signed from a CSR) are skipped and
+// reported with ErrCertMissingPrivateKey, and the revoked certificates with
+// ErrCertRevoked.
+//
+// Certificates already issued by the current CA Certificate are not issued
+// again, so an interrupted ReissueAll can be resumed by calling it again.
+func (c *CA) ReissueAll(valid int) (results map[string]error, err error) {
+	if c.Data.certificate == nil {
+		return nil, ErrCertInvalid
+	}
+
+	results = make(map[string]error)
+	for _, commonName := range c.ListCertificates() {
+		results[commonName] = c.reissueCertificate(commonName, valid)
+	}
+
+	return results, nil
+}
+
 // LoadCertificate loads a certificate managed by the Certificate Authority
 //
 // The method ListCertificates can be used to list all available certificates.
@@ -194,6 +1134,34 @@ func (c *CA) LoadCertificate(commonName string) (certificate Certificate, err er
 	return certificate, err
 }
 
+// LoadCertificateMeta loads only the certificate (*x509.Certificate) managed by
+// the Certificate Authority, skipping the keys and the CSR.
+//
+// It is lighter than LoadCertificate for read-only use.
+func (c *CA) LoadCertificateMeta(commonName string) (*x509.Certificate, error) {
+	certificate, err := c.loadCertificateMeta(commonName)
+	if err == nil && certificate == nil {
+		return nil, ErrCertLoadNotFound
+	}
+
+	return certificate, err
+}
+
+// SetCertificateMetadata stores application metadata (e.g. owner, ticket ID,
+// environment) with the certificate, replacing the previous metadata.
+//
+// The metadata is saved as JSON in the certificate folder and is not part of
+// the x509 certificate.
+func (c *CA) SetCertificateMetadata(commonName string, meta map[string]string) error {
+	return c.setCertificateMetadata(commonName, meta)
+}
+
+// GetCertificateMetadata returns the metadata stored with the certificate, or
+// an empty map when none is stored.
+func (c *CA) GetCertificateMetadata(commonName string) (map[string]string, error) {
+	return c.certificateMetadata(commonName)
+}
+
 // RevokeCertificate revokes a certificate managed by the Certificate Authority
 //
 // The method ListCertificates can be used to list all available certificates.
@@ -212,20 +1180,211 @@ func (c *CA) RevokeCertificate(commonName string) error {
 	return nil
 }
 
+// RevokeCertificateWithReason is RevokeCertificate with the reason code
+// (RevocationReason*) in the Certificate Revocation List entry, e.g.
+// RevocationReasonKeyCompromise. RevokeCertificate uses
+// RevocationReasonUnspecified, which has no reason code in the CRL entry.
+func (c *CA) RevokeCertificateWithReason(commonName string, reason int) error {
+
+	certToRevoke, err := c.loadCertificate(commonName)
+	if err != nil {
+		return err
+	}
+	if certToRevoke.certificate == nil {
+		return ErrCertLoadNotFound
+	}
+
+	return c.revokeSerial(certToRevoke.certificate.SerialNumber, reason)
+}
+
+// DeleteCertificate revokes the certificate, unless already revoked, and
+// removes its files (certs/<common name>/). It returns ErrCertLoadNotFound if
+// the certificate does not exist.
+//
+// The serial number stays in the CRL and in the issued serials.
+func (c *CA) DeleteCertificate(commonName string) error {
+	return c.deleteCertificate(commonName)
+}
+
+// UnrevokeCertificate removes the certificate from the Certificate Revocation
+// List, e.g. a certificate revoked by mistake, generating and storing the CRL
+// again. It returns ErrCertNotRevoked if the certificate is not revoked.
+func (c *CA) UnrevokeCertificate(commonName string) error {
+
+	certToUnrevoke, err := c.loadCertificate(commonName)
+	if err != nil {
+		return err
+	}
+
+	return c.unrevokeCertificate(certToUnrevoke.certificate)
+}
+
+// RefreshCRL generates, signs and stores the Certificate Revocation List again
+// with a fresh validity window of the CA CRL validity (WithCRLValidity) and the
+// next CRL number, keeping the current revoked certificates, e.g. to refresh
+// the CRL before its NextUpdate.
+func (c *CA) RefreshCRL() error {
+	_, err := c.refreshCRL(0)
+
+	return err
+}
+
+// RegenerateCRL is an alias of RefreshCRL, to proactively refresh the CRL
+// before it expires even without new revocations.
+func (c *CA) RegenerateCRL() error {
+	return c.RefreshCRL()
+}
+
+// PublishCRL generates, signs and stores the Certificate Revocation List again
+// with fresh ThisUpdate/NextUpdate and the next CRL number, returning it DER
+// encoded. The revoked certificates are unchanged.
+//
+// It is meant for scheduled CRL publication.
+func (c *CA) PublishCRL() ([]byte, error) {
+	return c.refreshCRL(0)
+}
+
+// PublishCRLFor is PublishCRL with the NextUpdate set to now + validity for
+// this publication, e.g. a longer-lived CRL before a maintenance window.
+//
+// The validity must be positive and at most cert.MaxCRLValidity.
+func (c *CA) PublishCRLFor(validity time.Duration) ([]byte, error) {
+	if validity <= 0 || validity > cert.MaxCRLValidity {
+		return nil, cert.ErrInvalidCRLValidity
+	}
+
+	return c.refreshCRL(validity)
+}
+
+// CompactCRL removes from the Certificate Revocation List the revoked
+// certificates already expired (RFC 5280 allows it, as they cannot be used
+// anymore) and signs the CRL again. Revoked serial numbers without a known
+// certificate are kept.
+//
+// The CRL is unchanged when no revoked certificate is removed.
+func (c *CA) CompactCRL() (removed int, err error) {
+	return c.compactCRL()
+}
+
+// RevokeSerial revokes a certificate by the serial number, adding it to the
+// Certificate Revocation List with the reason code (RevocationReason*).
+//
+// The certificate files are not required, so certificates already deleted
+// can be revoked.
+func (c *CA) RevokeSerial(serial *big.Int, reason int) error {
+	return c.revokeSerial(serial, reason)
+}
+
 //
 // Certificates
 //
 
+// ParseCertificate parses a PEM encoded certificate received out-of-band,
+// without CA or $CAPATH. The Certificate has no private key, CSR or CA
+// certificate.
+//
+// It returns ErrCertPEMInvalid if the data has no certificate and
+// ErrCertPEMMultiple if the data has more than one certificate.
+func ParseCertificate(pemBytes []byte) (certificate Certificate, err error) {
+	return parseCertificate(pemBytes, false)
+}
+
+// ParseCertificateChain parses a PEM encoded certificate followed by its CA
+// certificate chain, without CA or $CAPATH. The first CA certificate is the
+// issuer of the certificate.
+func ParseCertificateChain(pemBytes []byte) (certificate Certificate, err error) {
+	return parseCertificate(pemBytes, true)
+}
+
 // GetCertificate returns the certificate as string.
 func (c *Certificate) GetCertificate() string {
 	return c.Certificate
 }
 
-// GoCert returns the certificate as Go x509.Certificate.
+// GoCert returns the certificate as Go x509.Certificate, or the zero value if
+// the certificate is not available.
 func (c *Certificate) GoCert() x509.Certificate {
+	if c.certificate == nil {
+		return x509.Certificate{}
+	}
+
 	return *c.certificate
 }
 
+// GetPrivateKey returns the Private Key as string, empty if not available.
+func (c *Certificate) GetPrivateKey() string {
+	return c.PrivateKey
+}
+
+// GetPublicKey returns the Public Key as string, empty if not available.
+func (c *Certificate) GetPublicKey() string {
+	return c.PublicKey
+}
+
+// GoSigner returns the Private Key as crypto.Signer, or ErrCertMissingPrivateKey
+// (matching ErrNoPrivateKey) if the private key is not available.
+func (c *Certificate) GoSigner() (crypto.Signer, error) {
+	if c.signer == nil {
+		return nil, ErrCertMissingPrivateKey
+	}
+
+	return c.signer, nil
+}
+
+// ExportPrivateKey returns the Private Key encoded as PKCS#8 PEM (PRIVATE KEY)
+// for all key algorithms, or ErrCertMissingPrivateKey if not available.
+func (c *Certificate) ExportPrivateKey() ([]byte, error) {
+	signer, err := c.GoSigner()
+	if err != nil {
+		return nil, err
+	}
+
+	return key.MarshalPKCS8PEM(signer)
+}
+
+// ExportPrivateKeyDER returns the Private Key encoded as PKCS#8 DER, or
+// ErrCertMissingPrivateKey if not available.
+func (c *Certificate) ExportPrivateKeyDER() ([]byte, error) {
+	signer, err := c.GoSigner()
+	if err != nil {
+		return nil, err
+	}
+
+	return x509.MarshalPKCS8PrivateKey(signer)
+}
+
+// ExportEncryptedPrivateKey returns the Private Key encoded as PKCS#8 PEM
+// encrypted with the passphrase (ENCRYPTED PRIVATE KEY), or
+// ErrCertMissingPrivateKey if not available.
+func (c *Certificate) ExportEncryptedPrivateKey(passphrase []byte) ([]byte, error) {
+	signer, err := c.GoSigner()
+	if err != nil {
+		return nil, err
+	}
+
+	return key.MarshalEncryptedPKCS8PEM(signer, passphrase)
+}
+
+// KeyUsage returns the certificate key usage, or zero if the certificate is
+// not available.
+func (c *Certificate) KeyUsage() x509.KeyUsage {
+	if c.certificate == nil {
+		return 0
+	}
+
+	return c.certificate.KeyUsage
+}
+
+// ExtKeyUsage returns the certificate extended key usages, or nil if the
+// certificate is not available.
+func (c *Certificate) ExtKeyUsage() []x509.ExtKeyUsage {
+	if c.certificate == nil {
+		return nil
+	}
+
+	return c.certificate.ExtKeyUsage
+}
+
 // GetCSR returns the certificate as string.
 func (c *Certificate) GetCSR() string {
 	return c.CSR
@@ -241,7 +1400,89 @@ func (c *Certificate) GetCACertificate() string {
 	return c.CACertificate
 }
 
-// GoCACertificate returns the certificate *x509.Certificate.
+// GoCACertificate returns the certificate *x509.Certificate, or the zero value
+// if the CA certificate is not available.
 func (c *Certificate) GoCACertificate() x509.Certificate {
+	if c.caCertificate == nil {
+		return x509.Certificate{}
+	}
+
 	return *c.caCertificate
 }
+
+// IssuerCertificate returns the CA Certificate that signed the certificate,
+// matching the certificate Authority Key Identifier with the CA Certificate
+// Subject Key Identifier and checking the signature.
+func (c *Certificate) IssuerCertificate() (*x509.Certificate, error) {
+	return issuerCertificate(c.certificate, []*x509.Certificate{c.caCertificate})
+}
+
+// GetCertificateChain returns the certificate followed by the CA certificates
+// up to the root CA certificate as a PEM bundle, loading the parent CA
+// certificates from $CAPATH.
+//
+// If a parent CA certificate is not found, the chain found so far is returned
+// with ErrIssuerNotFound.
+func (c *Certificate) GetCertificateChain() (string, error) {
+	chainBytes, err := certificateChainBytes(c)
+
+	var chain string
+	for _, certBytes := range chainBytes {
+		chain += string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes}))
+	}
+
+	return chain, err
+}
+
+// GetCertificateChainBytes returns the certificate chain as GetCertificateChain
+// as DER, as used by tls.Certificate.
+func (c *Certificate) GetCertificateChainBytes() ([][]byte, error) {
+	return certificateChainBytes(c)
+}
+
+// TLSCertificate returns the certificate, the intermediate CA certificates and
+// the private key as tls.Certificate, ready for the tls.Config Certificates of
+// a server or client.
+//
+// It returns ErrCertMissingPrivateKey if the private key is not available and
+// ErrIssuerNotFound if a CA certificate of the chain is not found.
+func (c *Certificate) TLSCertificate() (tls.Certificate, error) {
+	return certificateTLS(c)
+}
+
+// ToPKCS12 returns the certificate, its private key and the CA certificate
+// chain as a password protected PKCS#12 (.p12/.pfx) file, to import into the
+// Windows and Java keystores. The friendly name is the certificate common name.
+//
+// It returns ErrCertMissingPrivateKey if the private key is not available.
+func (c *Certificate) ToPKCS12(password string) ([]byte, error) {
+	return certificatePKCS12(c, password, PKCS12Options{})
+}
+
+// PKCS12Options represents the options of ToPKCS12WithOptions.
+type PKCS12Options struct {
+	FriendlyName string // Label of the imported key and certificate in the keystores (default: the common name)
+}
+
+// ToPKCS12WithOptions is ToPKCS12 with the PKCS12Options, e.g. a friendly
+// name shown by the Windows and macOS keystores instead of the common name.
+func (c *Certificate) ToPKCS12WithOptions(password string, opts PKCS12Options) ([]byte, error) {
+	return certificatePKCS12(c, password, opts)
+}
+
+// NginxChain returns the certificate followed by the intermediate CA
+// certificates as PEM, as expected by the nginx ssl_certificate. The root CA
+// certificate is not included.
+func (c *Certificate) NginxChain() string {
+	if c.certificate == nil {
+		return c.Certificate
+	}
+
+	// the certificate may already embed the chain, start from the leaf
+	chain := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.certificate.Raw}))
+	for _, caCertificate := range certificateChain(c.location, c.caCertificate, false) {
+		chain += string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCertificate.Raw}))
+	}
+
+	return chain
+}
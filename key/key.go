@@ -30,65 +30,346 @@
 package key
 
 import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
 	"encoding/pem"
+	"errors"
+	"io"
+	"strings"
+	"sync"
 
 	storage "github.com/kairoaraujo/goca/_storage"
 )
 
-// KeysData represents the RSA keys with Private Key (Key) and Public Key (Public Key).
+// DefaultKeyBitSize is the RSA key size used when none is specified
+const DefaultKeyBitSize int = 2048
+
+// testModeSeed is the seed of the deterministic random source of the test mode
+const testModeSeed string = "goca test mode"
+
+var (
+	readerMu sync.RWMutex
+	reader   io.Reader = rand.Reader
+)
+
+// deterministicReader is a fixed random source (SHA-256 in counter mode) used
+// by the test mode. It is NOT secure.
+type deterministicReader struct {
+	mu      sync.Mutex
+	counter uint64
+	buf     []byte
+}
+
+func (r *deterministicReader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for n := 0; n < len(p); {
+		if len(r.buf) == 0 {
+			block := make([]byte, len(testModeSeed)+8)
+			copy(block, testModeSeed)
+			binary.BigEndian.PutUint64(block[len(testModeSeed):], r.counter)
+			r.counter++
+			sum := sha256.Sum256(block)
+			r.buf = sum[:]
+		}
+		copied := copy(p[n:], r.buf)
+		r.buf = r.buf[copied:]
+		n += copied
+	}
+
+	return len(p), nil
+}
+
+// SetTestMode enables (or disables) the test mode, where a fixed random
+// source is used to generate keys, making them reproducible.
+//
+// Never enable it in production: the keys are predictable. Recent Go versions
+// may ignore custom random sources for key generation, in that case the keys
+// are not reproducible.
+func SetTestMode(enabled bool) {
+	readerMu.Lock()
+	defer readerMu.Unlock()
+
+	if enabled {
+		reader = &deterministicReader{}
+	} else {
+		reader = rand.Reader
+	}
+}
+
+// Reader returns the random source used to generate keys and signatures:
+// crypto/rand.Reader, or the fixed random source in the test mode.
+func Reader() io.Reader {
+	readerMu.RLock()
+	defer readerMu.RUnlock()
+
+	return reader
+}
+
+// KeyAlgorithm is the public key algorithm of the keys
+type KeyAlgorithm int
+
+const (
+	// AlgorithmRSA is the RSA algorithm (default)
+	AlgorithmRSA KeyAlgorithm = iota
+	// AlgorithmEd25519 is the Ed25519 algorithm
+	AlgorithmEd25519
+	// AlgorithmECDSAP256 is the ECDSA algorithm with the NIST P-256 curve
+	AlgorithmECDSAP256
+	// AlgorithmECDSAP384 is the ECDSA algorithm with the NIST P-384 curve
+	AlgorithmECDSAP384
+)
+
+// keyAlgorithmNames are the names of the key algorithms
+var keyAlgorithmNames = map[KeyAlgorithm]string{
+	AlgorithmRSA:       "RSA",
+	AlgorithmEd25519:   "Ed25519",
+	AlgorithmECDSAP256: "ECDSA-P256",
+	AlgorithmECDSAP384: "ECDSA-P384",
+}
+
+// String returns the key algorithm name: RSA, Ed25519, ECDSA-P256 or ECDSA-P384
+func (a KeyAlgorithm) String() string {
+	if name, ok := keyAlgorithmNames[a]; ok {
+		return name
+	}
+
+	return "unknown"
+}
+
+// MarshalJSON encodes the key algorithm as the name
+func (a KeyAlgorithm) MarshalJSON() ([]byte, error) {
+	if _, ok := keyAlgorithmNames[a]; !ok {
+		return nil, ErrUnsupportedKeyAlgorithm
+	}
+
+	return json.Marshal(a.String())
+}
+
+// UnmarshalJSON decodes the key algorithm from the name (case-insensitive) or
+// the number
+func (a *KeyAlgorithm) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		var number int
+		if err := json.Unmarshal(data, &number); err != nil {
+			return err
+		}
+		if _, ok := keyAlgorithmNames[KeyAlgorithm(number)]; !ok {
+			return ErrUnsupportedKeyAlgorithm
+		}
+		*a = KeyAlgorithm(number)
+		return nil
+	}
+
+	for algorithm, algorithmName := range keyAlgorithmNames {
+		if strings.EqualFold(name, algorithmName) {
+			*a = algorithm
+			return nil
+		}
+	}
+
+	return ErrUnsupportedKeyAlgorithm
+}
+
+// ErrUnsupportedKeyAlgorithm means the key algorithm is not supported
+var ErrUnsupportedKeyAlgorithm = errors.New("unsupported key algorithm")
+
+// ErrInvalidPEMKey means the data is not a valid PEM encoded key
+var ErrInvalidPEMKey = errors.New("invalid PEM encoded key")
+
+// ErrPassphraseRequired means the private key is encrypted and no passphrase
+// was given to decrypt it
+var ErrPassphraseRequired = errors.New("the private key is encrypted, a passphrase is required")
+
+// ErrIncorrectPassphrase means the passphrase does not decrypt the private key
+var ErrIncorrectPassphrase = storage.ErrIncorrectPassphrase
+
+// KeyOptions represents the options used by CreateKeysWithOptions
+type KeyOptions struct {
+	Algorithm  KeyAlgorithm         // Key algorithm (default: RSA)
+	BitSize    int                  // RSA key bit size (default: DefaultKeyBitSize)
+	Location   storage.Location     // Base path and storage where the files are stored (default: $CAPATH in the file system)
+	Passphrase []byte               // Encrypts the private key as PKCS#8 with AES-256 (default: not encrypted)
+	KeyEncoder storage.KeyCodecFunc // Wraps the stored private key PEM (default: nil, the plain PEM)
+}
+
+// KeysData represents the keys with Private Key (Key) and Public Key (Public Key).
+//
+// Key and PublicKey are only filled for RSA keys, Signer and Public are filled
+// for all algorithms.
 type KeysData struct {
 	Key       rsa.PrivateKey
 	PublicKey rsa.PublicKey
+	Signer    crypto.Signer
+	Public    crypto.PublicKey
+	Algorithm KeyAlgorithm
 }
 
 // CreateKeys creates RSA private and public keyData that contains Key and PublicKey.
 //
 // The files are stored in the $CAPATH
 func CreateKeys(CACommonName, commonName string, creationType storage.CreationType, bitSize int) (KeysData, error) {
-	reader := rand.Reader
-	if bitSize == 0 {
-		bitSize = 2048
-	}
+	return CreateKeysWithAlgorithm(CACommonName, commonName, creationType, AlgorithmRSA, bitSize)
+}
 
-	key, err := rsa.GenerateKey(reader, bitSize)
+// CreateKeysWithAlgorithm creates private and public keyData of the algorithm.
+// The bitSize is used only by RSA.
+//
+// RSA keys are stored as PKCS#1, other algorithms as PKCS#8 (private key) and
+// PKIX (public key). The files are stored in the $CAPATH
+func CreateKeysWithAlgorithm(CACommonName, commonName string, creationType storage.CreationType, algorithm KeyAlgorithm, bitSize int) (KeysData, error) {
+	return CreateKeysIn("", CACommonName, commonName, creationType, algorithm, bitSize)
+}
 
-	if err != nil {
-		return KeysData{}, err
+// CreateKeysIn is CreateKeysWithAlgorithm storing the files in the base path
+// instead of the $CAPATH (empty base path is the $CAPATH).
+func CreateKeysIn(basePath, CACommonName, commonName string, creationType storage.CreationType, algorithm KeyAlgorithm, bitSize int) (KeysData, error) {
+	return CreateKeysWithOptions(CACommonName, commonName, creationType, KeyOptions{Algorithm: algorithm, BitSize: bitSize, Location: storage.Location{Path: basePath}})
+}
+
+// CreateKeysWithOptions creates private and public keyData using the
+// KeyOptions. With a passphrase, the private key is stored as encrypted PKCS#8
+// (ENCRYPTED PRIVATE KEY), loaded by LoadSignerWithPassphrase.
+func CreateKeysWithOptions(CACommonName, commonName string, creationType storage.CreationType, opts KeyOptions) (KeysData, error) {
+	return CreateKeysContext(context.Background(), CACommonName, commonName, creationType, opts)
+}
+
+// CreateKeysContext is CreateKeysWithOptions returning ctx.Err() if the
+// context is done before the keys are stored. The key generation itself (up
+// to seconds for large RSA keys) can not be interrupted, its result is
+// discarded and no file is written.
+func CreateKeysContext(ctx context.Context, CACommonName, commonName string, creationType storage.CreationType, opts KeyOptions) (KeysData, error) {
+	type generated struct {
+		fileData storage.File
+		keys     KeysData
+		err      error
 	}
 
-	publicKey := key.PublicKey
+	done := make(chan generated, 1)
+	go func() {
+		fileData, keys, err := generateKeys(opts.Algorithm, opts.BitSize)
+		done <- generated{fileData, keys, err}
+	}()
 
-	fileData := storage.File{
-		CA:             CACommonName,
-		CommonName:     commonName,
-		FileType:       storage.FileTypeKey,
-		PrivateKeyData: key,
-		PublicKeyData:  publicKey,
-		CreationType:   creationType,
+	var result generated
+	select {
+	case <-ctx.Done():
+		return KeysData{}, ctx.Err()
+	case result = <-done:
+	}
+	if result.err != nil {
+		return KeysData{}, result.err
+	}
+	if err := ctx.Err(); err != nil {
+		return KeysData{}, err
 	}
 
-	err = storage.SaveFile(fileData)
+	fileData := result.fileData
+	fileData.CA = CACommonName
+	fileData.CommonName = commonName
+	fileData.FileType = storage.FileTypeKey
+	fileData.CreationType = creationType
+	fileData.Location = opts.Location
+	fileData.Passphrase = opts.Passphrase
+	fileData.KeyEncoder = opts.KeyEncoder
+
+	err := storage.SaveFile(fileData)
 	if err != nil {
 		return KeysData{}, err
 	}
 
-	keys := KeysData{
-		Key:       *key,
-		PublicKey: publicKey,
+	return result.keys, nil
+}
+
+// generateKeys generates the keys of the algorithm, returning the key data to
+// be stored in fileData
+func generateKeys(algorithm KeyAlgorithm, bitSize int) (fileData storage.File, keys KeysData, err error) {
+	reader := Reader()
+	keys.Algorithm = algorithm
+
+	switch algorithm {
+	case AlgorithmRSA:
+		if bitSize == 0 {
+			bitSize = DefaultKeyBitSize
+		}
+
+		key, err := rsa.GenerateKey(reader, bitSize)
+		if err != nil {
+			return fileData, KeysData{}, err
+		}
+
+		fileData.PrivateKeyData = key
+		fileData.PublicKeyData = key.PublicKey
+		keys.Key = *key
+		keys.PublicKey = key.PublicKey
+		keys.Signer = key
+		keys.Public = &key.PublicKey
+
+	case AlgorithmEd25519:
+		publicKey, privateKey, err := ed25519.GenerateKey(reader)
+		if err != nil {
+			return fileData, KeysData{}, err
+		}
+
+		fileData.SignerData = privateKey
+		fileData.PublicData = publicKey
+		keys.Signer = privateKey
+		keys.Public = publicKey
+
+	case AlgorithmECDSAP256, AlgorithmECDSAP384:
+		curve := elliptic.P256()
+		if algorithm == AlgorithmECDSAP384 {
+			curve = elliptic.P384()
+		}
+
+		privateKey, err := ecdsa.GenerateKey(curve, reader)
+		if err != nil {
+			return fileData, KeysData{}, err
+		}
+
+		fileData.SignerData = privateKey
+		fileData.PublicData = &privateKey.PublicKey
+		keys.Signer = privateKey
+		keys.Public = &privateKey.PublicKey
+
+	default:
+		return fileData, KeysData{}, ErrUnsupportedKeyAlgorithm
 	}
 
-	return keys, nil
+	return fileData, keys, nil
 }
 
 // LoadPrivateKey loads a RSA Private Key from a read file.
 //
 // Using ioutil.ReadFile() satisfyies it.
 func LoadPrivateKey(keyString []byte) (*rsa.PrivateKey, error) {
-	block, _ := pem.Decode([]byte(string(keyString)))
-	privateKey, _ := x509.ParsePKCS1PrivateKey(block.Bytes)
+	return LoadPrivateKeyWithPassphrase(keyString, nil)
+}
+
+// LoadPrivateKeyWithPassphrase is LoadPrivateKey also loading the encrypted
+// PKCS#8 private keys with the passphrase, see LoadSignerWithPassphrase.
+func LoadPrivateKeyWithPassphrase(keyString, passphrase []byte) (*rsa.PrivateKey, error) {
+	signer, err := LoadSignerWithPassphrase(keyString, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, ok := signer.(*rsa.PrivateKey)
+	if !ok {
+		return nil, ErrUnsupportedKeyAlgorithm
+	}
 
 	return privateKey, nil
 }
@@ -97,8 +378,147 @@ func LoadPrivateKey(keyString []byte) (*rsa.PrivateKey, error) {
 //
 // Using ioutil.ReadFile() satisfyies it.
 func LoadPublicKey(keyString []byte) (*rsa.PublicKey, error) {
-	block, _ := pem.Decode([]byte(string(keyString)))
-	publicKey, _ := x509.ParsePKCS1PublicKey(block.Bytes)
+	public, err := LoadPublic(keyString)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, ok := public.(*rsa.PublicKey)
+	if !ok {
+		return nil, ErrUnsupportedKeyAlgorithm
+	}
 
 	return publicKey, nil
 }
+
+// LoadSigner loads a Private Key of any supported algorithm from a read file,
+// detecting the encoding: PKCS#1 (RSA), SEC 1 (ECDSA) or PKCS#8.
+func LoadSigner(keyString []byte) (crypto.Signer, error) {
+	return LoadSignerWithPassphrase(keyString, nil)
+}
+
+// LoadSignerWithPassphrase is LoadSigner also loading the encrypted PKCS#8
+// private keys (ENCRYPTED PRIVATE KEY) with the passphrase.
+//
+// An encrypted private key without passphrase returns ErrPassphraseRequired
+// and with a wrong passphrase ErrIncorrectPassphrase.
+func LoadSignerWithPassphrase(keyString, passphrase []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyString)
+	if block == nil {
+		return nil, ErrInvalidPEMKey
+	}
+
+	if block.Type == storage.EncryptedPEMBlockType {
+		if len(passphrase) == 0 {
+			return nil, ErrPassphraseRequired
+		}
+
+		derBytes, err := storage.DecryptPKCS8(block.Bytes, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		// a wrong passphrase may still have a valid padding
+		if _, err := x509.ParsePKCS8PrivateKey(derBytes); err != nil {
+			return nil, ErrIncorrectPassphrase
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: derBytes}
+	}
+
+	if privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return privateKey, nil
+	}
+
+	if block.Type == "EC PRIVATE KEY" {
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+
+	privateKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	switch privateKey := privateKey.(type) {
+	case *rsa.PrivateKey:
+		return privateKey, nil
+	case ed25519.PrivateKey:
+		return privateKey, nil
+	case *ecdsa.PrivateKey:
+		return privateKey, nil
+	}
+
+	return nil, ErrUnsupportedKeyAlgorithm
+}
+
+// LoadPublic loads a Public Key of any supported algorithm from a read file,
+// encoded as PKCS#1 (RSA) or PKIX.
+func LoadPublic(keyString []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(keyString)
+	if block == nil {
+		return nil, ErrInvalidPEMKey
+	}
+
+	if publicKey, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return publicKey, nil
+	}
+
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	switch publicKey := publicKey.(type) {
+	case *rsa.PublicKey:
+		return publicKey, nil
+	case ed25519.PublicKey:
+		return publicKey, nil
+	case *ecdsa.PublicKey:
+		return publicKey, nil
+	}
+
+	return nil, ErrUnsupportedKeyAlgorithm
+}
+
+// PublicKeyFingerprint returns the SHA-256 fingerprint (hex) of the public key
+// DER encoded as PKIX (SubjectPublicKeyInfo).
+func PublicKeyFingerprint(publicKey interface{}) (string, error) {
+	derBytes, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+
+	fingerprint := sha256.Sum256(derBytes)
+
+	return hex.EncodeToString(fingerprint[:]), nil
+}
+
+// MarshalPKCS8PEM encodes the private key of any supported algorithm as PKCS#8
+// PEM (PRIVATE KEY).
+func MarshalPKCS8PEM(privateKey crypto.Signer) ([]byte, error) {
+	derBytes, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: derBytes}), nil
+}
+
+// MarshalEncryptedPKCS8PEM encodes the private key of any supported algorithm
+// as PKCS#8 PEM encrypted with the passphrase (ENCRYPTED PRIVATE KEY), readable
+// by LoadSignerWithPassphrase.
+func MarshalEncryptedPKCS8PEM(privateKey crypto.Signer, passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, ErrPassphraseRequired
+	}
+
+	derBytes, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := storage.EncryptPKCS8(derBytes, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(block), nil
+}
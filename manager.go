@@ -0,0 +1,142 @@
+package goca
+
+import (
+	"sync"
+	"time"
+
+	storage "github.com/kairoaraujo/goca/_storage"
+)
+
+// Manager manages multiple Certificate Authorities in $CAPATH, loading each
+// CA once on demand and keeping it in memory.
+//
+// It is safe for concurrent use.
+type Manager struct {
+	mu       sync.Mutex
+	cas      map[string]*managedCA
+	options  []Option         // Options loading the CAs, e.g. WithPath or WithStorage
+	location storage.Location // Base path and storage of the CAs files (default: $CAPATH in the file system)
+}
+
+type managedCA struct {
+	once    sync.Once
+	ca      CA
+	err     error
+	loaded  bool      // guarded by Manager.mu
+	modTime time.Time // guarded by Manager.mu
+}
+
+// NewManager creates a new Manager. The options are used to load the CAs, so
+// the CAs in another path (WithPath) or storage (WithStorage) are managed.
+func NewManager(options ...Option) *Manager {
+	var ca CA
+	ca.applyOptions(options)
+
+	return &Manager{
+		cas:      make(map[string]*managedCA),
+		options:  options,
+		location: ca.location,
+	}
+}
+
+// Get returns the Certificate Authority, loading it from $CAPATH in the first
+// call. Concurrent calls for the same CA load it only once.
+func (m *Manager) Get(commonName string) (CA, error) {
+	m.mu.Lock()
+	entry, ok := m.cas[commonName]
+	if !ok {
+		entry = &managedCA{}
+		m.cas[commonName] = entry
+	}
+	m.mu.Unlock()
+
+	entry.once.Do(func() {
+		modTime, _ := storage.LatestModTimeIn(m.location, commonName, "ca")
+		entry.ca, entry.err = LoadWithOptions(commonName, m.options...)
+
+		m.mu.Lock()
+		entry.modTime = modTime
+		entry.loaded = true
+		m.mu.Unlock()
+	})
+
+	// errors are not kept, the next Get tries to load again
+	if entry.err != nil {
+		m.mu.Lock()
+		if m.cas[commonName] == entry {
+			delete(m.cas, commonName)
+		}
+		m.mu.Unlock()
+	}
+
+	return entry.ca, entry.err
+}
+
+// Reload loads the Certificate Authority again from $CAPATH
+func (m *Manager) Reload(commonName string) (CA, error) {
+	m.mu.Lock()
+	delete(m.cas, commonName)
+	m.mu.Unlock()
+
+	return m.Get(commonName)
+}
+
+// List list all existent Certificate Authorities in $CAPATH
+func (m *Manager) List() []string {
+	return listCAs(m.location)
+}
+
+// Watch checks the loaded Certificate Authorities files in $CAPATH every
+// interval and drops the changed CAs, so the next Get loads them again.
+//
+// Watch blocks until stop is closed.
+func (m *Manager) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			m.dropChanged()
+		}
+	}
+}
+
+func (m *Manager) dropChanged() {
+	type cached struct {
+		commonName string
+		entry      *managedCA
+		modTime    time.Time
+	}
+
+	// the storage is walked without holding the lock, not to block Get
+	var entries []cached
+	m.mu.Lock()
+	for commonName, entry := range m.cas {
+		// entries still loading are checked in the next interval
+		if entry.loaded {
+			entries = append(entries, cached{commonName, entry, entry.modTime})
+		}
+	}
+	m.mu.Unlock()
+
+	var changed []cached
+	for _, check := range entries {
+		modTime, err := storage.LatestModTimeIn(m.location, check.commonName, "ca")
+		if err != nil || modTime.After(check.modTime) {
+			changed = append(changed, check)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// entries reloaded meanwhile are kept
+	for _, check := range changed {
+		if m.cas[check.commonName] == check.entry {
+			delete(m.cas, check.commonName)
+		}
+	}
+}
@@ -74,15 +74,32 @@ func payloadInit(json models.Payload) (commonName, parentCommonName string, iden
 	commonName = json.CommonName
 	parentCommonName = json.ParentCommonName
 	identity = goca.Identity{
-		Organization:       json.Identity.Organization,
-		OrganizationalUnit: json.Identity.OrganizationalUnit,
-		Country:            json.Identity.Country,
-		Locality:           json.Identity.Locality,
-		Province:           json.Identity.Province,
-		DNSNames:           json.Identity.DNSNames,
-		Intermediate:       json.Identity.Intermediate,
-		KeyBitSize:         json.Identity.KeyBitSize,
-		Valid:              json.Identity.Valid,
+		Organization:        json.Identity.Organization,
+		OrganizationalUnit:  json.Identity.OrganizationalUnit,
+		Country:             json.Identity.Country,
+		Locality:            json.Identity.Locality,
+		Province:            json.Identity.Province,
+		EmailAddresses:      json.Identity.EmailAddresses,
+		EmailPlacement:      json.Identity.EmailPlacement,
+		DNSNames:            json.Identity.DNSNames,
+		Intermediate:        json.Identity.Intermediate,
+		KeyBitSize:          json.Identity.KeyBitSize,
+		KeyAlgorithm:        json.Identity.KeyAlgorithm,
+		Valid:               json.Identity.Valid,
+		PolicyOIDs:          json.Identity.PolicyOIDs,
+		CPSURIs:             json.Identity.CPSURIs,
+		UPNs:                json.Identity.UPNs,
+		PermittedDNSDomains: json.Identity.PermittedDNSDomains,
+		ExcludedDNSDomains:  json.Identity.ExcludedDNSDomains,
+		OCSPNoCheck:         json.Identity.OCSPNoCheck,
+		KeepDNSNames:        json.Identity.KeepDNSNames,
+		ValidityJitter:      json.Identity.ValidityJitter,
+		OCSPServers:         json.Identity.OCSPServers,
+		SubjectSerialNumber: json.Identity.SubjectSerialNumber,
+		CAIssuersURLs:       json.Identity.CAIssuersURLs,
+		CRLURLs:             json.Identity.CRLURLs,
+		EmbedChain:          json.Identity.EmbedChain,
+		EmbedRoot:           json.Identity.EmbedRoot,
 	}
 
 	return commonName, parentCommonName, identity
@@ -239,8 +256,7 @@ func UploadCertificateICA(c *gin.Context) {
 	os.Remove(fileNameFull)
 
 	// Generate the initial CRL
-	privKey := ca.GoPrivateKey()
-	_, err = cert.RevokeCertificate(ca.CommonName, []pkix.RevokedCertificate{}, ca.GoCertificate(), &privKey)
+	_, err = cert.RevokeCertificate(ca.CommonName, []pkix.RevokedCertificate{}, ca.GoCertificate(), ca.GoSigner())
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return